@@ -9,6 +9,8 @@ import (
 	log "github.com/go-language/logger"
 	"github.com/go-language/rediscache"
 	"github.com/go-language/session"
+	"html/template"
+	"io/fs"
 	"net/http"
 	"net/smtp"
 	"path"
@@ -37,13 +39,31 @@ const (
 	ActionSuffix  = ""
 	DefaultAction = "Index"
 
-	ViewDir       = "views"
-	ViewSuffix    = ".html"
-	ViewLayout    = "layout.html"
-	ViewLayoutDir = "layouts"
+	ViewDir           = "views"
+	ViewSuffix        = ".html"
+	ViewLayout        = "layout.html"
+	ViewLayoutDir     = "layouts"
+	ViewErrorDir      = "errors"
+	ViewWidgetDir     = "widgets"
+	DefaultViewEngine = ViewEngineMustache
 
-	EnableSession = true
-	SessionName   = "GOSESSION"
+	ThemeDir = "themes"
+
+	EnableSession     = true
+	SessionName       = "GOSESSION"
+	SessionStore      = "REDIS"
+	SessionFileDir    = "sessions"
+	SessionGcInterval = 600
+	SessionCookiePath = "/"
+	SessionSameSite   = "Lax"
+
+	EnableCache     = true
+	CacheDriver     = "REDIS"
+	CacheMemorySize = 10000
+	CacheFileDir    = "cache"
+
+	AssetOutputDir = "resources/assets"
+	AssetOutputUrl = "/resources/assets"
 
 	LogDir  = "logs"
 	LogName = "app.log"
@@ -58,30 +78,47 @@ const (
 )
 
 type Application struct {
-	name         string
-	mode         int
-	basePath     string
-	state        int
-	language     string
-	port         string
-	hosts        Hosts
-	defaultHost  *Host
-	Config       *Config
-	errorHandler ErrorHandler
-	sessionStore session.Store
-	Logger       *log.Logger
-	Cache        *rediscache.RedisCache
+	name          string
+	mode          int
+	basePath      string
+	state         int
+	language      string
+	port          string
+	hosts         Hosts
+	defaultHost   *Host
+	Config        *Config
+	errorHandler  ErrorHandler
+	errorReporter ErrorReporter
+	sessionStore  session.Store
+	sessionIndex  SessionIndex
+	Logger        *log.Logger
+	Cache         Cache
+	viewEngine    ViewEngine
+	viewFS        fs.FS
+	assetBundles  map[string]*AssetBundle
+	viewHelpers   map[string]ViewHelper
+	viewFuncs     template.FuncMap
+	widgets       map[string]Widget
+	themes        map[string]*Theme
+	messages      map[string]map[string]string
+	redisCache    *rediscache.RedisCache
 }
 
 func NewApplication() Application {
 	return Application{
-		state:       StateUninitialized,
-		name:        "Cheetah Application",
-		basePath:    "",
-		mode:        ModePro,
-		language:    "en",
-		hosts:       make(Hosts),
-		defaultHost: nil,
+		state:        StateUninitialized,
+		name:         "Cheetah Application",
+		basePath:     "",
+		mode:         ModePro,
+		language:     "en",
+		hosts:        make(Hosts),
+		assetBundles: make(map[string]*AssetBundle),
+		viewHelpers:  defaultViewHelpers(),
+		viewFuncs:    defaultViewFuncs(),
+		widgets:      make(map[string]Widget),
+		themes:       make(map[string]*Theme),
+		messages:     make(map[string]map[string]string),
+		defaultHost:  nil,
 		Config: &Config{
 			// Server configuration
 			serverPort:     ServerPort,
@@ -99,16 +136,37 @@ func NewApplication() Application {
 			defaultAction: DefaultAction,
 
 			// View configuration
-			viewLayout:    ViewLayout,
-			viewLayoutDir: ViewLayoutDir,
-			viewDir:       ViewDir,
-			viewSuffix:    ViewSuffix,
+			viewLayout:     ViewLayout,
+			viewLayoutDir:  ViewLayoutDir,
+			viewDir:        ViewDir,
+			viewSuffix:     ViewSuffix,
+			viewErrorDir:   ViewErrorDir,
+			viewWidgetDir:  ViewWidgetDir,
+			viewEngine:     DefaultViewEngine,
+			viewPrecompile: false,
+
+			// Theme configuration
+			themeDir: ThemeDir,
 
 			// Session configuration
-			enableSession: EnableSession,
-			sessionName:   SessionName,
-			sessionStore:  "REDIS",
-			sessionMaxAge: 10 * 24 * 3600,
+			enableSession:     EnableSession,
+			sessionName:       SessionName,
+			sessionStore:      SessionStore,
+			sessionMaxAge:     10 * 24 * 3600,
+			sessionSecret:     "",
+			sessionFileDir:    SessionFileDir,
+			sessionFilePath:   "",
+			sessionGcInterval: SessionGcInterval,
+
+			sessionCookiePath:      SessionCookiePath,
+			sessionCookieDomain:    "",
+			sessionCookieSecure:    false,
+			sessionCookieHttpOnly:  true,
+			sessionCookieSameSite:  SessionSameSite,
+			sessionIdleTimeout:     0,
+			sessionAbsoluteTimeout: 0,
+			sessionEnableIndex:     false,
+			sessionMaxConcurrent:   0,
 
 			// CSRF configuration
 			enableCsrfValidation: EnableCsrfValidation,
@@ -144,7 +202,21 @@ func NewApplication() Application {
 			routerHandleOPTIONS:          true,
 
 			// Cache configuration
-			enableCache:      true,
+			enableCache:     EnableCache,
+			cacheDriver:     CacheDriver,
+			cacheMemorySize: CacheMemorySize,
+			cacheFileDir:    CacheFileDir,
+			cacheFilePath:   "",
+			cachePrefix:     "",
+			cacheStaleTtl:   0,
+			cacheRedisLock:  false,
+
+			// Asset configuration
+			assetCombine:   false,
+			assetOutputDir: AssetOutputDir,
+			assetOutputUrl: AssetOutputUrl,
+
+			// Redis configuration
 			redisNetwork:     "tcp",
 			redisAddress:     ":6379",
 			redisPassword:    "",
@@ -250,6 +322,37 @@ func (this *Application) loadConfig(filename string) {
 	if err == nil {
 		this.Config.viewLayoutDir = viewLayoutDir
 	}
+	viewErrorDir, err := section.GetString("view.error_dir")
+	if err == nil {
+		this.Config.viewErrorDir = viewErrorDir
+	}
+	viewWidgetDir, err := section.GetString("view.widget_dir")
+	if err == nil {
+		this.Config.viewWidgetDir = viewWidgetDir
+	}
+	viewEngine, err := section.GetString("view.engine")
+	if err == nil {
+		this.Config.viewEngine = viewEngine
+	}
+	viewSearchPath, err := section.GetString("view.search_path")
+	if err == nil {
+		this.Config.viewSearchPath = []string{}
+		for _, dir := range strings.Split(viewSearchPath, ",") {
+			if dir = strings.TrimSpace(dir); len(dir) > 0 {
+				this.Config.viewSearchPath = append(this.Config.viewSearchPath, dir)
+			}
+		}
+	}
+	viewPrecompile, err := section.GetBool("view.precompile")
+	if err == nil {
+		this.Config.viewPrecompile = viewPrecompile
+	}
+
+	// Set theme configuration
+	themeDir, err := section.GetString("theme.dir")
+	if err == nil {
+		this.Config.themeDir = themeDir
+	}
 
 	// Set session configuration
 	enableSession, err := section.GetBool("session.enable")
@@ -268,8 +371,108 @@ func (this *Application) loadConfig(filename string) {
 	if err == nil {
 		this.Config.sessionStore = sessionStore
 	}
+	sessionSecret, err := section.GetString("session.secret")
+	if err == nil {
+		this.Config.sessionSecret = sessionSecret
+	}
+	sessionFileDir, err := section.GetString("session.file_dir")
+	if err == nil {
+		this.Config.sessionFileDir = sessionFileDir
+	}
+	sessionFilePath, err := section.GetString("session.file_path")
+	if err == nil {
+		this.Config.sessionFilePath = sessionFilePath
+	}
+	sessionGcInterval, err := section.GetInt("session.gc_interval")
+	if err == nil {
+		this.Config.sessionGcInterval = sessionGcInterval
+	}
+	sessionCookiePath, err := section.GetString("session.cookie_path")
+	if err == nil {
+		this.Config.sessionCookiePath = sessionCookiePath
+	}
+	sessionCookieDomain, err := section.GetString("session.cookie_domain")
+	if err == nil {
+		this.Config.sessionCookieDomain = sessionCookieDomain
+	}
+	sessionCookieSecure, err := section.GetBool("session.cookie_secure")
+	if err == nil {
+		this.Config.sessionCookieSecure = sessionCookieSecure
+	}
+	sessionCookieHttpOnly, err := section.GetBool("session.cookie_http_only")
+	if err == nil {
+		this.Config.sessionCookieHttpOnly = sessionCookieHttpOnly
+	}
+	sessionCookieSameSite, err := section.GetString("session.cookie_same_site")
+	if err == nil {
+		this.Config.sessionCookieSameSite = sessionCookieSameSite
+	}
+	sessionIdleTimeout, err := section.GetInt("session.idle_timeout")
+	if (err == nil) && (sessionIdleTimeout >= 0) {
+		this.Config.sessionIdleTimeout = sessionIdleTimeout
+	}
+	sessionAbsoluteTimeout, err := section.GetInt("session.absolute_timeout")
+	if (err == nil) && (sessionAbsoluteTimeout >= 0) {
+		this.Config.sessionAbsoluteTimeout = sessionAbsoluteTimeout
+	}
+	sessionEnableIndex, err := section.GetBool("session.enable_index")
+	if err == nil {
+		this.Config.sessionEnableIndex = sessionEnableIndex
+	}
+	sessionMaxConcurrent, err := section.GetInt("session.max_concurrent")
+	if (err == nil) && (sessionMaxConcurrent >= 0) {
+		this.Config.sessionMaxConcurrent = sessionMaxConcurrent
+	}
+
+	// Set cache configuration
+	enableCache, err := section.GetBool("cache.enable")
+	if err == nil {
+		this.Config.enableCache = enableCache
+	}
+	cacheDriver, err := section.GetString("cache.driver")
+	if err == nil {
+		this.Config.cacheDriver = cacheDriver
+	}
+	cacheMemorySize, err := section.GetInt("cache.memory_size")
+	if (err == nil) && (cacheMemorySize >= 0) {
+		this.Config.cacheMemorySize = cacheMemorySize
+	}
+	cacheFileDir, err := section.GetString("cache.file_dir")
+	if err == nil {
+		this.Config.cacheFileDir = cacheFileDir
+	}
+	cacheFilePath, err := section.GetString("cache.file_path")
+	if err == nil {
+		this.Config.cacheFilePath = cacheFilePath
+	}
+	cachePrefix, err := section.GetString("cache.prefix")
+	if err == nil {
+		this.Config.cachePrefix = cachePrefix
+	}
+	cacheStaleTtl, err := section.GetInt("cache.stale_ttl")
+	if (err == nil) && (cacheStaleTtl >= 0) {
+		this.Config.cacheStaleTtl = cacheStaleTtl
+	}
+	cacheRedisLock, err := section.GetBool("cache.redis_lock")
+	if err == nil {
+		this.Config.cacheRedisLock = cacheRedisLock
+	}
+
+	// Set asset configuration
+	assetCombine, err := section.GetBool("asset.combine")
+	if err == nil {
+		this.Config.assetCombine = assetCombine
+	}
+	assetOutputDir, err := section.GetString("asset.output_dir")
+	if err == nil {
+		this.Config.assetOutputDir = assetOutputDir
+	}
+	assetOutputUrl, err := section.GetString("asset.output_url")
+	if err == nil {
+		this.Config.assetOutputUrl = assetOutputUrl
+	}
 
-	// Set Redis Cache configuration
+	// Set Redis configuration
 	redisMaxIdle, err := section.GetInt("redis.max_idle")
 	if err == nil {
 		this.Config.redisMaxIdle = redisMaxIdle
@@ -438,11 +641,64 @@ func (this *Application) validateConfig() {
 		}
 	}
 
+	// Check cache configuration
+	if this.Config.enableCache {
+		switch strings.ToUpper(this.Config.cacheDriver) {
+		case CacheDriverMemory, CacheDriverRedis:
+		case CacheDriverFile:
+			if len(this.Config.cacheFilePath) == 0 {
+				this.Config.cacheFilePath = path.Join(this.basePath, this.Config.cacheFileDir)
+			}
+		default:
+			panic("The cache driver is not supported: " + this.Config.cacheDriver + ", only support MEMORY, FILE and REDIS.")
+		}
+	}
+
 	// Check session configuration
 	if this.Config.enableSession {
 		if len(this.Config.sessionName) == 0 {
 			panic("The session can not be empty string.")
 		}
+		switch strings.ToUpper(this.Config.sessionStore) {
+		case SessionStoreMemory, SessionStoreRedis:
+		case SessionStoreCookie:
+			if len(this.Config.sessionSecret) < 16 {
+				panic("The cookie session store requires a secret which length is at least 16, please set session.secret.")
+			}
+		case SessionStoreFile:
+			if len(this.Config.sessionFilePath) == 0 {
+				this.Config.sessionFilePath = path.Join(this.basePath, this.Config.sessionFileDir)
+			}
+		default:
+			panic("The session store is not supported: " + this.Config.sessionStore + ", only support MEMORY, COOKIE, FILE and REDIS.")
+		}
+		if len(this.Config.sessionCookiePath) == 0 {
+			this.Config.sessionCookiePath = SessionCookiePath
+		}
+		sameSite := strings.ToUpper(this.Config.sessionCookieSameSite)
+		switch sameSite {
+		case "", "LAX", "STRICT", "NONE":
+		default:
+			panic("The session cookie's SameSite is not supported: " + this.Config.sessionCookieSameSite + ", only support Lax, Strict and None.")
+		}
+		// Refuse the insecure session cookie in production mode.
+		if this.mode == ModePro {
+			if (sameSite == "NONE") && !this.Config.sessionCookieSecure {
+				panic("The session cookie's SameSite=None requires Secure, please set session.cookie_secure = on.")
+			}
+			if strings.EqualFold("HTTPS", this.Config.serverProtocol) && !this.Config.sessionCookieSecure {
+				panic("The session cookie must be secure under HTTPS, please set session.cookie_secure = on.")
+			}
+			if !this.Config.sessionCookieHttpOnly {
+				fmt.Println("The session cookie is accessible to JavaScript, it is recommended to set session.cookie_http_only = on.")
+			}
+		}
+		if (this.Config.sessionAbsoluteTimeout > 0) && (this.Config.sessionIdleTimeout > this.Config.sessionAbsoluteTimeout) {
+			panic("The session.idle_timeout can not be greater than session.absolute_timeout.")
+		}
+		if (this.Config.sessionMaxConcurrent > 0) && !this.Config.sessionEnableIndex {
+			panic("The session.max_concurrent depends on the session index, please set session.enable_index = on.")
+		}
 	}
 
 	// Check CSRF configuration
@@ -525,37 +781,53 @@ func (this *Application) run() {
 		}
 	}
 
-	// Register Cache
-	if this.Config.enableCache {
-		redisPool := rediscache.NewRedisPool(
-			this.Config.redisMaxIdle,
-			this.Config.redisIdleTimeout,
-			this.Config.redisNetwork,
-			this.Config.redisAddress,
-			this.Config.redisPassword,
-			this.Config.redisDb,
-		)
+	// Register Cache, the cache which set by SetCache will be used if it is not nil.
+	if this.Config.enableCache && (this.Cache == nil) {
+		SetCache(this.newCache())
+	}
 
-		defer redisPool.Close()
+	// Register view engine, the engine which set by SetViewEngine will be used if it is not nil.
+	if this.viewEngine == nil {
+		SetViewEngine(this.newViewEngine())
+	}
 
-		this.Cache = rediscache.NewRedisCache(redisPool)
+	// The themes override the views by the relative names, they are not supported by the legacy views under GOPATH.
+	if (len(this.themes) > 0) && !this.isViewFSEnabled() {
+		panic("The themes require the view file system, please invoke SetViewFS or set the view.search_path.")
 	}
 
-	// Register session store
-	if this.Config.enableSession {
-		if !this.Config.enableCache {
-			panic("The session depends on redis cache, please enable the cache component.")
+	// Precompile the views, so that the errors are reported before serving.
+	if (this.mode == ModePro) && this.Config.viewPrecompile {
+		if err := this.precompileViews(); err != nil {
+			panic(err.Error())
 		}
+	}
 
-		store := session.NewRedisStore(this.Cache.GetPool(), session.Options{})
+	// Validate the dependencies of the asset bundles, so that the errors are reported before serving.
+	if err := validateAssetBundles(); err != nil {
+		panic(err.Error())
+	}
 
-		if err != nil {
-			panic(err)
+	// Build the asset bundles, so that the concatenated and minified files are used.
+	if (this.mode == ModePro) && this.Config.assetCombine {
+		if err := BuildAssetBundles(path.Join(this.basePath, this.Config.assetOutputDir), this.Config.assetOutputUrl); err != nil {
+			panic(err.Error())
 		}
+	}
 
-		store.SetMaxAge(this.Config.sessionMaxAge)
+	// Register session store, the store which set by SetSessionStore will be used if it is not nil.
+	if this.Config.enableSession && (this.sessionStore == nil) {
+		SetSessionStore(this.newSessionStore())
+	}
 
-		SetSessionStore(store)
+	// Register session index, the index which set by SetSessionIndex will be used if it is not nil.
+	if this.Config.enableSession && this.Config.sessionEnableIndex && (this.sessionIndex == nil) {
+		SetSessionIndex(this.newSessionIndex())
+	}
+
+	// Close the redis pool which was created by the components.
+	if this.redisCache != nil {
+		defer this.redisCache.GetPool().Close()
 	}
 
 	this.state = StateRuning
@@ -579,6 +851,11 @@ func (this *Application) run() {
 		}
 	}
 
+	// Collect the request-scoped information for the debug page.
+	if this.mode == ModeDev {
+		handler = newDebugHandler(handler)
+	}
+
 	addr := ":" + this.Config.serverPort
 	// If the protocol equal HTTPS
 	if strings.EqualFold("HTTPS", this.Config.serverProtocol) {
@@ -597,6 +874,24 @@ func (this *Application) run() {
 	}
 }
 
+// Get the redis cache, the redis pool will be created on first access.
+// It is shared by the redis cache driver, the redis session store and the redis session index.
+func (this *Application) getRedisCache() *rediscache.RedisCache {
+	if this.redisCache == nil {
+		redisPool := rediscache.NewRedisPool(
+			this.Config.redisMaxIdle,
+			this.Config.redisIdleTimeout,
+			this.Config.redisNetwork,
+			this.Config.redisAddress,
+			this.Config.redisPassword,
+			this.Config.redisDb,
+		)
+
+		this.redisCache = rediscache.NewRedisCache(redisPool)
+	}
+	return this.redisCache
+}
+
 // Register route handler.
 func (this *Application) registerRouteHandler() {
 	for _, host := range this.hosts {
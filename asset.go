@@ -2,21 +2,25 @@ package cheetah
 
 import (
 	"fmt"
-	"strings"
+	"html"
+	"sort"
 )
 
+// Asset is rendered in the head or footer block of the view.
+// The options and condition are set by the concrete types, so that the calls can be chained,
+// such as view.AddHeaderCss(NewCssAsset(href).Option("media", "print")).
 type Asset interface {
 	Output() string
-	Option(key, value string) Asset
-	Condition(condition string) Asset
 }
 
 type CssAsset struct {
-	Href      string
-	Rel       string
-	Type      string
-	condition string
-	Options   map[string]string
+	Href        string
+	Rel         string
+	Type        string
+	Integrity   string // the Subresource Integrity hash, such as "sha384-...".
+	CrossOrigin string // the crossorigin attribute, it is "anonymous" if it is empty and the integrity is set.
+	condition   string
+	Options     map[string]string
 }
 
 func NewCssAsset(href string) *CssAsset {
@@ -30,25 +34,12 @@ func NewCssAsset(href string) *CssAsset {
 }
 
 func (this *CssAsset) Output() string {
-	args := make([]interface{}, 0)
-	format := "<link rel=\"%s\" type=\"%s\" href=\"%s\"/>"
-	args = append(args, this.Rel, this.Type, this.Href)
-
-	options := make([]string, 0)
-	if len(this.Options) > 0 {
-		format = "<link rel=\"%s\" type=\"%s\" href=\"%s\" %s/>"
-		for k, v := range this.Options {
-			options = append(options, k+"=\""+v+"\"")
-		}
-		args = append(args, strings.Join(options, " "))
-	}
-
-	asset := fmt.Sprintf(format, args...)
-
-	if len(this.condition) > 0 {
-		asset = "<!--[if " + this.condition + "]> -->" + asset + "<!-- <![endif]-->"
-	}
-	return asset
+	asset := fmt.Sprintf(
+		"<link rel=\"%s\" type=\"%s\" href=\"%s\"%s%s/>",
+		html.EscapeString(this.Rel), html.EscapeString(this.Type), html.EscapeString(this.Href),
+		formatAssetIntegrity(this.Integrity, this.CrossOrigin), formatAssetOptions(this.Options),
+	)
+	return wrapAssetCondition(asset, this.condition)
 }
 
 func (this *CssAsset) Option(key, value string) *CssAsset {
@@ -62,11 +53,13 @@ func (this *CssAsset) Condition(condition string) *CssAsset {
 }
 
 type JsAsset struct {
-	Src       string // it links a  javascript source file  If src is not empty.
-	Script    string // it is a javascript if script is not empty.
-	Type      string
-	condition string
-	Options   map[string]string
+	Src         string // it links a  javascript source file  If src is not empty.
+	Script      string // it is a javascript if script is not empty.
+	Type        string
+	Integrity   string // the Subresource Integrity hash of the source file, such as "sha384-...".
+	CrossOrigin string // the crossorigin attribute, it is "anonymous" if it is empty and the integrity is set.
+	condition   string
+	Options     map[string]string
 }
 
 func NewJsAsset(src, script string) *JsAsset {
@@ -79,41 +72,22 @@ func NewJsAsset(src, script string) *JsAsset {
 	}
 }
 
+// The script is not escaped, it must be trusted.
 func (this *JsAsset) Output() string {
-	args := make([]interface{}, 0)
-	format := ""
-
+	asset := ""
 	if len(this.Src) > 0 {
-		args = append(args, this.Type, this.Src)
-		if len(this.Options) > 0 {
-			format = "<script type=\"%s\" src=\"%s\" %s></script>"
-		} else {
-			format = "<script type=\"%s\" src=\"%s\"></script>"
-		}
+		asset = fmt.Sprintf(
+			"<script type=\"%s\" src=\"%s\"%s%s></script>",
+			html.EscapeString(this.Type), html.EscapeString(this.Src),
+			formatAssetIntegrity(this.Integrity, this.CrossOrigin), formatAssetOptions(this.Options),
+		)
 	} else {
-		args = append(args, this.Type, this.Script)
-		if len(this.Options) > 0 {
-			format = "<script type=\"%s\" %s></script>"
-		} else {
-			format = "<script type=\"%s\">%s</script>"
-		}
-	}
-
-	options := make([]string, 0)
-	if len(this.Options) > 0 {
-		format = "<link rel=\"%s\" type=\"%s\" href=\"%s\" %s/>"
-		for k, v := range this.Options {
-			options = append(options, k+"=\""+v+"\"")
-		}
-		args = append(args, strings.Join(options, " "))
-	}
-
-	asset := fmt.Sprintf(format, args...)
-
-	if len(this.condition) > 0 {
-		asset = "<!--[if " + this.condition + "]> -->" + asset + "<!-- <![endif]-->"
+		asset = fmt.Sprintf(
+			"<script type=\"%s\"%s>%s</script>",
+			html.EscapeString(this.Type), formatAssetOptions(this.Options), this.Script,
+		)
 	}
-	return asset
+	return wrapAssetCondition(asset, this.condition)
 }
 
 func (this *JsAsset) Option(key, value string) *JsAsset {
@@ -125,3 +99,41 @@ func (this *JsAsset) Condition(condition string) *JsAsset {
 	this.condition = condition
 	return this
 }
+
+// Format the options as the escaped attributes in order of keys, such as ` async="async"`.
+func formatAssetOptions(options map[string]string) string {
+	if len(options) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := ""
+	for _, key := range keys {
+		attributes += " " + html.EscapeString(key) + "=\"" + html.EscapeString(options[key]) + "\""
+	}
+	return attributes
+}
+
+// Format the integrity and crossorigin attributes, empty string will be returned if the integrity is empty.
+func formatAssetIntegrity(integrity, crossOrigin string) string {
+	if len(integrity) == 0 {
+		return ""
+	}
+	if len(crossOrigin) == 0 {
+		crossOrigin = "anonymous"
+	}
+	return " integrity=\"" + html.EscapeString(integrity) + "\" crossorigin=\"" + html.EscapeString(crossOrigin) + "\""
+}
+
+// Wrap the asset in the conditional comment, such as "lt IE 9".
+func wrapAssetCondition(asset string, condition string) string {
+	if len(condition) > 0 {
+		return "<!--[if " + condition + "]> -->" + asset + "<!-- <![endif]-->"
+	}
+	return asset
+}
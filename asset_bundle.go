@@ -0,0 +1,364 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The query param of the fingerprint, the resources which are requested with it are cached by the client forever.
+const assetVersionParam = "v"
+
+// Asset bundle, it is a group of CSS and JavaScript files which depend on the other bundles.
+// The bundles are registered by RegisterAssetBundle, and used by View.RegisterAssetBundle,
+// the dependencies will be rendered before the bundle.
+// The URLs of the files are fingerprinted by the hash of their contents if the file system is set,
+// such as "/resources/css/app.css?v=5d41402abc4b", and the integrity(SRI) hashes are emitted,
+// the hashes are cached in ModePro.
+// The files can be concatenated and minified by Build, the built files are used instead of the files.
+// If the files are served by Host.RegisterThemeResources, the bundle should be Themed, so that the fingerprints
+// and the integrity hashes are computed from the theme's files which override the bundle's files.
+type AssetBundle struct {
+	Name       string
+	BaseUrl    string            // the URL which the files are served from, such as "/resources".
+	FS         fs.FS             // the file system of the files, it is used to compute the fingerprints.
+	Css        []string          // the CSS files, they are relative to the BaseUrl.
+	Js         []string          // the JavaScript files, they are relative to the BaseUrl.
+	Depends    []string          // the names of the bundles which this bundle depends on.
+	JsInHead   bool              // whether to render the JavaScript files in the head block, they are rendered in the footer default.
+	CssOptions map[string]string // the options of the CSS assets.
+	JsOptions  map[string]string // the options of the JavaScript assets.
+	Themed     bool              // whether the files are overridden by the theme's static resources.
+
+	mutex  sync.RWMutex
+	hashes map[string]*assetHash
+	build  *assetBuild
+}
+
+// The hashes of the file.
+type assetHash struct {
+	fingerprint string
+	integrity   string
+}
+
+func NewAssetBundle(name, baseUrl string, fsys fs.FS) *AssetBundle {
+	return &AssetBundle{
+		Name:       name,
+		BaseUrl:    baseUrl,
+		FS:         fsys,
+		Css:        make([]string, 0),
+		Js:         make([]string, 0),
+		Depends:    make([]string, 0),
+		CssOptions: make(map[string]string),
+		JsOptions:  make(map[string]string),
+		hashes:     make(map[string]*assetHash),
+	}
+}
+
+func (this *AssetBundle) AddCss(files ...string) *AssetBundle {
+	this.Css = append(this.Css, files...)
+	return this
+}
+
+func (this *AssetBundle) AddJs(files ...string) *AssetBundle {
+	this.Js = append(this.Js, files...)
+	return this
+}
+
+func (this *AssetBundle) DependsOn(names ...string) *AssetBundle {
+	this.Depends = append(this.Depends, names...)
+	return this
+}
+
+// Get the URL and the integrity of the file, the absolute URL(such as CDN) is returned directly.
+// The theme's file is hashed instead if the bundle is themed and the theme is not nil.
+func (this *AssetBundle) url(file string, theme *Theme) (string, string) {
+	if isAbsoluteUrl(file) {
+		return file, ""
+	}
+	url := strings.TrimRight(this.BaseUrl, "/") + "/" + strings.TrimLeft(file, "/")
+	hash := this.hash(file, this.getTheme(theme))
+	if hash == nil {
+		return url, ""
+	}
+	return url + "?" + assetVersionParam + "=" + hash.fingerprint, hash.integrity
+}
+
+// Get the theme which overrides the bundle's files, nil will be returned if the bundle is not themed.
+func (this *AssetBundle) getTheme(theme *Theme) *Theme {
+	if !this.Themed {
+		return nil
+	}
+	return theme
+}
+
+// Get the hashes of the file, nil will be returned if the file system is not set or failed to read the file.
+func (this *AssetBundle) hash(file string, theme *Theme) *assetHash {
+	if this.FS == nil {
+		return nil
+	}
+
+	key := file
+	if theme != nil {
+		key = theme.Name + ":" + file
+	}
+	cacheable := App.mode != ModeDev
+	if cacheable {
+		this.mutex.RLock()
+		hash, ok := this.hashes[key]
+		this.mutex.RUnlock()
+		if ok {
+			return hash
+		}
+	}
+
+	data, err := this.readFile(file, theme)
+	if err != nil {
+		return nil
+	}
+	hash := newAssetHash(data)
+
+	if cacheable {
+		this.mutex.Lock()
+		if this.hashes == nil {
+			this.hashes = make(map[string]*assetHash)
+		}
+		this.hashes[key] = hash
+		this.mutex.Unlock()
+	}
+	return hash
+}
+
+// Read the file, it is read through the theme's static resources if the theme is not nil.
+func (this *AssetBundle) readFile(file string, theme *Theme) ([]byte, error) {
+	fsys := this.FS
+	if theme != nil {
+		fsys = theme.getResourceFS(fsys)
+	}
+	return fs.ReadFile(fsys, strings.TrimLeft(path.Clean(file), "/"))
+}
+
+// Returns a boolean indicating whether the theme overrides any of the files.
+func (this *AssetBundle) isOverridden(files []string, theme *Theme) bool {
+	if theme == nil {
+		return false
+	}
+	fsys := theme.getResourceFS(nil)
+	for _, file := range files {
+		if !isAbsoluteUrl(file) && isViewFile(fsys, strings.TrimLeft(path.Clean(file), "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compute the fingerprint(the prefix of SHA256) and the integrity(SHA384) of the content.
+func newAssetHash(data []byte) *assetHash {
+	sum := sha256.Sum256(data)
+	integrity := sha512.Sum384(data)
+	return &assetHash{
+		fingerprint: hex.EncodeToString(sum[:])[:12],
+		integrity:   "sha384-" + base64.StdEncoding.EncodeToString(integrity[:]),
+	}
+}
+
+func (this *AssetBundle) getBuild() *assetBuild {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return this.build
+}
+
+// Get the CSS assets, the built files are not used if the theme overrides the files, because they are built from the bundle's files.
+func (this *AssetBundle) cssAssets(theme *Theme) []*CssAsset {
+	theme = this.getTheme(theme)
+	if build := this.getBuild(); (build != nil) && (len(build.css) > 0) && !this.isOverridden(this.Css, theme) {
+		assets := make([]*CssAsset, 0, len(build.css))
+		for _, file := range build.css {
+			assets = append(assets, this.newCssAsset(file.url, file.integrity))
+		}
+		return assets
+	}
+
+	assets := make([]*CssAsset, 0, len(this.Css))
+	for _, file := range this.Css {
+		url, integrity := this.url(file, theme)
+		assets = append(assets, this.newCssAsset(url, integrity))
+	}
+	return assets
+}
+
+func (this *AssetBundle) newCssAsset(url, integrity string) *CssAsset {
+	asset := NewCssAsset(url)
+	asset.Integrity = integrity
+	for key, value := range this.CssOptions {
+		asset.Options[key] = value
+	}
+	return asset
+}
+
+func (this *AssetBundle) jsAssets(theme *Theme) []*JsAsset {
+	theme = this.getTheme(theme)
+	if build := this.getBuild(); (build != nil) && (len(build.js) > 0) && !this.isOverridden(this.Js, theme) {
+		assets := make([]*JsAsset, 0, len(build.js))
+		for _, file := range build.js {
+			assets = append(assets, this.newJsAsset(file.url, file.integrity))
+		}
+		return assets
+	}
+
+	assets := make([]*JsAsset, 0, len(this.Js))
+	for _, file := range this.Js {
+		url, integrity := this.url(file, theme)
+		assets = append(assets, this.newJsAsset(url, integrity))
+	}
+	return assets
+}
+
+func (this *AssetBundle) newJsAsset(url, integrity string) *JsAsset {
+	asset := NewJsAsset(url, "")
+	asset.Integrity = integrity
+	for key, value := range this.JsOptions {
+		asset.Options[key] = value
+	}
+	return asset
+}
+
+func isAbsoluteUrl(url string) bool {
+	return strings.HasPrefix(url, "//") || strings.Contains(url, "://")
+}
+
+// Register the asset bundle, the bundle which has the same name will be replaced.
+// It should be invoked before running the application, the dependencies are validated when running,
+// because the bundles can be registered in any order.
+func RegisterAssetBundle(bundle *AssetBundle) {
+	App.assetBundles[bundle.Name] = bundle
+}
+
+// Validate the dependencies of the registered bundles.
+func validateAssetBundles() error {
+	names := make([]string, 0, len(App.assetBundles))
+	for name := range App.assetBundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	_, err := resolveAssetBundles(names)
+	return err
+}
+
+// Resolve the bundles and their dependencies in dependency order, the dependencies come first.
+// The error is returned if a bundle is not registered or the dependencies are circular.
+func resolveAssetBundles(names []string) ([]*AssetBundle, error) {
+	bundles := make([]*AssetBundle, 0)
+	states := make(map[string]int) // 1: visiting, 2: visited.
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch states[name] {
+		case 1:
+			return errors.New("The asset bundles have circular dependencies: " + name)
+		case 2:
+			return nil
+		}
+		bundle, ok := App.assetBundles[name]
+		if !ok {
+			return errors.New("The asset bundle is not registered: " + name)
+		}
+
+		states[name] = 1
+		for _, dependency := range bundle.Depends {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		states[name] = 2
+		bundles = append(bundles, bundle)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return bundles, nil
+}
+
+// The far-future caching header of the fingerprinted resources.
+const resourceCacheControl = "public, max-age=31536000, immutable"
+
+// Generate the handle of the static resources.
+// The resources which are requested with their fingerprints are cached by the client forever,
+// the other versions are served without the caching header, because they are not the requested contents.
+func newResourceHandle(root http.FileSystem) httprouter.Handle {
+	fileServer := http.FileServer(root)
+	fingerprints := newResourceFingerprints(root)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name := ps.ByName("filepath")
+		if version := r.URL.Query().Get(assetVersionParam); (len(version) > 0) && (version == fingerprints.get(name)) {
+			w.Header().Set("Cache-Control", resourceCacheControl)
+		}
+		r.URL.Path = name
+		fileServer.ServeHTTP(w, r)
+	}
+}
+
+// The fingerprints of the static resources, they are cached in ModePro.
+type resourceFingerprints struct {
+	root   http.FileSystem
+	mutex  sync.RWMutex
+	hashes map[string]string
+}
+
+func newResourceFingerprints(root http.FileSystem) *resourceFingerprints {
+	return &resourceFingerprints{
+		root:   root,
+		hashes: make(map[string]string),
+	}
+}
+
+// Get the fingerprint of the resource, the empty string will be returned if failed to read it.
+func (this *resourceFingerprints) get(name string) string {
+	cacheable := App.mode != ModeDev
+	if cacheable {
+		this.mutex.RLock()
+		fingerprint, ok := this.hashes[name]
+		this.mutex.RUnlock()
+		if ok {
+			return fingerprint
+		}
+	}
+
+	file, err := this.root.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if info, err := file.Stat(); (err != nil) || info.IsDir() {
+		return ""
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return ""
+	}
+	fingerprint := newAssetHash(data).fingerprint
+
+	if cacheable {
+		this.mutex.Lock()
+		this.hashes[name] = fingerprint
+		this.mutex.Unlock()
+	}
+	return fingerprint
+}
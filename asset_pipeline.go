@@ -0,0 +1,326 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The built files of the asset bundle.
+type assetBuild struct {
+	css []*assetBuildFile // the built CSS files in the declared order.
+	js  []*assetBuildFile // the built JavaScript files in the declared order.
+}
+
+// The built file, or the absolute URL which is not combined.
+type assetBuildFile struct {
+	url       string
+	integrity string
+}
+
+// Concatenate and minify the CSS and JavaScript files of the bundle respectively, and write them into the
+// output directory with the fingerprinted names, such as "app.5d41402abc4b.css", the outputUrl is the URL
+// which the output directory is served from. The built files are used instead of the bundle's files,
+// and they are requested with the fingerprints, so that they are cached by the client forever.
+// The absolute URLs(such as CDN) are not combined, the files between them are combined separately,
+// so that the declared order is kept. The relative URLs in the CSS files are rewritten to the URLs
+// under the BaseUrl, because the built files are served from the outputUrl.
+func (this *AssetBundle) Build(outputDir, outputUrl string) error {
+	if this.FS == nil {
+		return errors.New("The file system of the asset bundle is not set: " + this.Name)
+	}
+
+	build := &assetBuild{}
+	var err error
+	build.css, err = this.combine(this.Css, ".css", "\n", func(file, src string) string {
+		return minifyCss(this.rewriteCssUrls(file, src))
+	}, outputDir, outputUrl)
+	if err != nil {
+		return err
+	}
+	build.js, err = this.combine(this.Js, ".js", ";\n", func(file, src string) string {
+		return minifyJs(src)
+	}, outputDir, outputUrl)
+	if err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	this.build = build
+	this.mutex.Unlock()
+	return nil
+}
+
+// Combine the consecutive files into a file, returns the built files and the absolute URLs in the declared order.
+func (this *AssetBundle) combine(files []string, ext, separator string, minify func(file, src string) string, outputDir, outputUrl string) ([]*assetBuildFile, error) {
+	built := make([]*assetBuildFile, 0)
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		data := buf.Bytes()
+		hash := newAssetHash(data)
+		name := this.Name + "." + hash.fingerprint + ext
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(outputDir, name), data, 0644); err != nil {
+			return err
+		}
+		url := strings.TrimRight(outputUrl, "/") + "/" + name + "?" + assetVersionParam + "=" + hash.fingerprint
+		built = append(built, &assetBuildFile{url: url, integrity: hash.integrity})
+		buf.Reset()
+		return nil
+	}
+
+	for _, file := range files {
+		if isAbsoluteUrl(file) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			built = append(built, &assetBuildFile{url: file})
+			continue
+		}
+		data, err := this.readFile(file, nil)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(separator)
+		}
+		buf.WriteString(minify(file, string(data)))
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return built, nil
+}
+
+var (
+	cssUrlRegexp    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'"()\s]*))\s*\)`)
+	cssImportRegexp = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// Rewrite the relative URLs of the CSS file to the URLs under the BaseUrl, such as url(../img/bg.png)
+// of "css/app.css" is rewritten to url(/resources/img/bg.png).
+func (this *AssetBundle) rewriteCssUrls(file, src string) string {
+	dir := path.Dir(strings.TrimLeft(path.Clean(file), "/"))
+	rewrite := func(url string) string {
+		if (len(url) == 0) || strings.HasPrefix(url, "/") || strings.HasPrefix(url, "#") || strings.Contains(url, ":") {
+			return url
+		}
+		return strings.TrimRight(this.BaseUrl, "/") + "/" + path.Join(dir, url)
+	}
+	replace := func(re *regexp.Regexp, prefix, suffix string) func(string) string {
+		return func(match string) string {
+			m := re.FindStringSubmatch(match)
+			for i, quote := range []string{"\"", "'", ""} {
+				if (i+1 < len(m)) && (len(m[i+1]) > 0) {
+					return prefix + quote + rewrite(m[i+1]) + quote + suffix
+				}
+			}
+			return match
+		}
+	}
+	src = cssUrlRegexp.ReplaceAllStringFunc(src, replace(cssUrlRegexp, "url(", ")"))
+	return cssImportRegexp.ReplaceAllStringFunc(src, replace(cssImportRegexp, "@import ", ""))
+}
+
+// Build all the registered asset bundles which have the file system.
+func BuildAssetBundles(outputDir, outputUrl string) error {
+	names := make([]string, 0, len(App.assetBundles))
+	for name := range App.assetBundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		bundle := App.assetBundles[name]
+		if bundle.FS == nil {
+			continue
+		}
+		if err := bundle.Build(outputDir, outputUrl); err != nil {
+			return errors.New("Failed to build the asset bundle " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func isCssSpace(c byte) bool {
+	return (c == ' ') || (c == '\t') || (c == '\n') || (c == '\r') || (c == '\f')
+}
+
+// The index after the string which starts at i, the string is terminated by the same quote.
+func skipQuoted(src string, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// Minify the CSS, it removes the comments and the unnecessary whitespaces.
+func minifyCss(src string) string {
+	out := make([]byte, 0, len(src))
+	space := false
+	// The whitespaces around them are unnecessary.
+	const separators = "{};,>"
+
+	write := func(token string) {
+		if space && (len(out) > 0) && !strings.ContainsRune(separators, rune(out[len(out)-1])) && !strings.ContainsRune(separators, rune(token[0])) {
+			out = append(out, ' ')
+		}
+		space = false
+		if (token[0] == '}') && (len(out) > 0) && (out[len(out)-1] == ';') {
+			out = out[:len(out)-1]
+		}
+		out = append(out, token...)
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case (c == '"') || (c == '\''):
+			j := skipQuoted(src, i)
+			write(src[i:j])
+			i = j - 1
+		case (c == '/') && (i+1 < len(src)) && (src[i+1] == '*'):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 3
+			}
+			space = true
+		case isCssSpace(c):
+			space = true
+		default:
+			write(src[i : i+1])
+		}
+	}
+	return string(out)
+}
+
+// The keywords which may be followed by a regular expression.
+var jsRegexpKeywords = []string{"return", "typeof", "case", "do", "else", "in", "of", "void", "yield", "delete", "new", "throw", "instanceof"}
+
+// Returns a boolean indicating whether the slash which follows the output starts a regular expression.
+func isJsRegexpStart(out []byte) bool {
+	end := len(out)
+	for (end > 0) && ((out[end-1] == ' ') || (out[end-1] == '\n')) {
+		end--
+	}
+	if end == 0 {
+		return true
+	}
+	if strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(out[end-1])) {
+		return true
+	}
+	for _, keyword := range jsRegexpKeywords {
+		if bytes.HasSuffix(out[:end], []byte(keyword)) {
+			start := end - len(keyword)
+			if (start == 0) || !isJsIdentifierChar(out[start-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isJsIdentifierChar(c byte) bool {
+	return ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || ((c >= '0') && (c <= '9')) || (c == '_') || (c == '$')
+}
+
+// The index after the regular expression which starts at i.
+func skipJsRegexp(src string, i int) int {
+	class := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				return j + 1
+			}
+		case '\n':
+			return j
+		}
+	}
+	return len(src)
+}
+
+// Minify the JavaScript conservatively, it removes the comments, the blank lines and the unnecessary whitespaces,
+// but the line breaks are kept, so that the automatic semicolon insertion is not affected.
+func minifyJs(src string) string {
+	out := make([]byte, 0, len(src))
+	space, newline := false, false
+
+	write := func(token string) {
+		if len(out) > 0 {
+			if newline {
+				out = append(out, '\n')
+			} else if space {
+				out = append(out, ' ')
+			}
+		}
+		space, newline = false, false
+		out = append(out, token...)
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case (c == '"') || (c == '\'') || (c == '`'):
+			j := skipQuoted(src, i)
+			write(src[i:j])
+			i = j - 1
+		case (c == '/') && (i+1 < len(src)) && (src[i+1] == '/'):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end - 1
+			}
+		case (c == '/') && (i+1 < len(src)) && (src[i+1] == '*'):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				end = len(src) - i - 2
+			}
+			if strings.IndexByte(src[i+2:i+2+end], '\n') >= 0 {
+				newline = true
+			} else {
+				space = true
+			}
+			i += end + 3
+		case (c == '/') && isJsRegexpStart(out):
+			j := skipJsRegexp(src, i)
+			write(src[i:j])
+			i = j - 1
+		case c == '\n':
+			newline = true
+		case (c == ' ') || (c == '\t') || (c == '\r'):
+			space = true
+		default:
+			write(src[i : i+1])
+		}
+	}
+	return string(out)
+}
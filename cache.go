@@ -0,0 +1,331 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CacheDriverMemory = "MEMORY"
+	CacheDriverFile   = "FILE"
+	CacheDriverRedis  = "REDIS"
+)
+
+// ErrCacheMiss is returned by Cache.Get if the key does not exist or has expired.
+var ErrCacheMiss = errors.New("Cache miss.")
+
+// Cache interface.
+// The values stored in the redis and file caches are encoded by gob, the custom types must be
+// registered by gob.Register, and the in-memory cache stores the values directly.
+type Cache interface {
+	// Get the value of the key, ErrCacheMiss will be returned if the key does not exist.
+	Get(key string) (interface{}, error)
+
+	// Get the values of the keys, the missing keys are not contained in the returned map.
+	GetMulti(keys ...string) (map[string]interface{}, error)
+
+	// Set the value of the key, the key will never expire if ttl is 0.
+	Set(key string, value interface{}, ttl time.Duration) error
+
+	// Set the value of the key with the dependencies, the value will be invalidated
+	// when any dependency changed, such as the tags were invalidated by InvalidateTags.
+	SetWithDependency(key string, value interface{}, ttl time.Duration, dependencies ...CacheDependency) error
+
+	// Invalidate the values which depend on the tags.
+	InvalidateTags(tags ...string) error
+
+	// Delete the key.
+	Delete(key string) error
+
+	// Returns a boolean indicating whether the key exists, the dependencies are not evaluated.
+	Has(key string) (bool, error)
+
+	// Increase the integer value of the key by delta, and returns the new value.
+	// The key will be set as delta if it does not exist.
+	Incr(key string, delta int64) (int64, error)
+
+	// Get the value of the key, or compute it by fn and store it if the key does not exist.
+	// The concurrent computations of the same key are deduplicated.
+	Remember(key string, ttl time.Duration, fn RememberFunc) (interface{}, error)
+
+	// Get the statistics of Remember.
+	Stats() CacheStats
+}
+
+// Create the cache according to the cache.driver configuration.
+func (this *Application) newCache() Cache {
+	staleTtl := time.Duration(this.Config.cacheStaleTtl) * time.Second
+
+	switch strings.ToUpper(this.Config.cacheDriver) {
+	case CacheDriverMemory:
+		cache := NewMemoryCache(this.Config.cacheMemorySize)
+		cache.SetStaleTtl(staleTtl)
+		return cache
+	case CacheDriverFile:
+		cache := NewFileCache(this.Config.cacheFilePath)
+		cache.SetStaleTtl(staleTtl)
+		return cache
+	case CacheDriverRedis:
+		pool := this.getRedisCache().GetPool()
+		cache := NewRedisCache(func() redisConn { return pool.Get() }, this.Config.cachePrefix)
+		cache.SetStaleTtl(staleTtl)
+		cache.SetDistributedLock(this.Config.cacheRedisLock)
+		return cache
+	}
+	panic("The cache driver is not supported: " + this.Config.cacheDriver + ", only support MEMORY, FILE and REDIS.")
+}
+
+// The cached item, it is used to encode the value by gob.
+type cacheItem struct {
+	Value interface{}
+}
+
+func encodeCacheValue(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&cacheItem{Value: value}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCacheValue(data []byte) (interface{}, error) {
+	item := &cacheItem{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(item); err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// Convert the integer value to int64.
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("The value %v is not an integer.", value)
+}
+
+// Increase the stored integer value by delta, returns the value to store and the increased integer.
+// The value stored by Remember is increased in its item, so that its freshness is kept.
+func incrCacheValue(value interface{}, delta int64) (interface{}, int64, error) {
+	if item, ok := value.(rememberItem); ok {
+		n, err := toInt64(item.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		item.Value = n + delta
+		return item, n + delta, nil
+	}
+	n, err := toInt64(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return n + delta, n + delta, nil
+}
+
+// In-memory cache, the least recently used items will be evicted if the number of items exceeds the size.
+type MemoryCache struct {
+	cacheRemember
+	size  int
+	mutex sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+type memoryCacheItem struct {
+	key    string
+	value  interface{}
+	expire time.Time
+}
+
+func (this *memoryCacheItem) expired(now time.Time) bool {
+	return !this.expire.IsZero() && now.After(this.expire)
+}
+
+// Create an in-memory cache, the size is the maximum number of items, 0 means unlimited.
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Get the item and move it to the front, it must be invoked with the lock.
+func (this *MemoryCache) get(key string, now time.Time) (*memoryCacheItem, bool) {
+	element, ok := this.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryCacheItem)
+	if item.expired(now) {
+		this.remove(element)
+		return nil, false
+	}
+	this.lru.MoveToFront(element)
+	return item, true
+}
+
+// Set the item, it must be invoked with the lock.
+func (this *MemoryCache) set(key string, value interface{}, ttl time.Duration, now time.Time) {
+	expire := time.Time{}
+	if ttl > 0 {
+		expire = now.Add(ttl)
+	}
+
+	if element, ok := this.items[key]; ok {
+		item := element.Value.(*memoryCacheItem)
+		item.value = value
+		item.expire = expire
+		this.lru.MoveToFront(element)
+		return
+	}
+
+	this.items[key] = this.lru.PushFront(&memoryCacheItem{key: key, value: value, expire: expire})
+	if (this.size > 0) && (this.lru.Len() > this.size) {
+		this.remove(this.lru.Back())
+	}
+}
+
+func (this *MemoryCache) remove(element *list.Element) {
+	this.lru.Remove(element)
+	delete(this.items, element.Value.(*memoryCacheItem).key)
+}
+
+func (this *MemoryCache) Get(key string) (interface{}, error) {
+	value, err := this.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return getRememberedValue(value), nil
+}
+
+// Get the item without unwrapping the value stored by Remember.
+func (this *MemoryCache) getItem(key string) (interface{}, error) {
+	this.mutex.Lock()
+	item, ok := this.get(key, time.Now())
+	var value interface{}
+	if ok {
+		value = item.value
+	}
+	this.mutex.Unlock()
+
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	// The dependencies are evaluated without the lock, because they may access the cache.
+	return resolveCacheItem(this, key, value)
+}
+
+func (this *MemoryCache) GetMulti(keys ...string) (map[string]interface{}, error) {
+	now := time.Now()
+	values := make(map[string]interface{}, len(keys))
+	this.mutex.Lock()
+	for _, key := range keys {
+		if item, ok := this.get(key, now); ok {
+			values[key] = item.value
+		}
+	}
+	this.mutex.Unlock()
+
+	for key, value := range values {
+		if value, err := resolveCacheValue(this, key, value); err == nil {
+			values[key] = value
+		} else {
+			delete(values, key)
+		}
+	}
+	return values, nil
+}
+
+func (this *MemoryCache) Set(key string, value interface{}, ttl time.Duration) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.set(key, value, ttl, time.Now())
+	return nil
+}
+
+func (this *MemoryCache) SetWithDependency(key string, value interface{}, ttl time.Duration, dependencies ...CacheDependency) error {
+	return setWithDependency(this, key, value, ttl, dependencies)
+}
+
+func (this *MemoryCache) InvalidateTags(tags ...string) error {
+	return invalidateTags(this, tags)
+}
+
+func (this *MemoryCache) Delete(key string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if element, ok := this.items[key]; ok {
+		this.remove(element)
+	}
+	return nil
+}
+
+func (this *MemoryCache) Has(key string) (bool, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	_, ok := this.get(key, time.Now())
+	return ok, nil
+}
+
+// The TTL of the existing key will be kept.
+func (this *MemoryCache) Incr(key string, delta int64) (int64, error) {
+	now := time.Now()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	item, ok := this.get(key, now)
+	if !ok {
+		this.set(key, delta, 0, now)
+		return delta, nil
+	}
+
+	value, result, err := incrCacheValue(item.value, delta)
+	if err != nil {
+		return 0, err
+	}
+	item.value = value
+	return result, nil
+}
+
+func (this *MemoryCache) Remember(key string, ttl time.Duration, fn RememberFunc) (interface{}, error) {
+	return this.remember(this, key, ttl, fn)
+}
+
+// Returns the number of items, including the expired items which have not been evicted.
+func (this *MemoryCache) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.lru.Len()
+}
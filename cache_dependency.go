@@ -0,0 +1,223 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/HeadwindFly/cheetah/utils/string"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache dependency interface.
+// The dependency is evaluated when the value is stored, and evaluated again when the value is read,
+// the value will be invalidated if the evaluated data changed.
+// The dependencies are stored with the value, so they must be registered by gob.Register
+// if they are used with the redis and file caches.
+type CacheDependency interface {
+	// Evaluate the dependency, returns the data which is used to detect the change.
+	Evaluate(cache Cache) (string, error)
+}
+
+func init() {
+	gob.Register(dependentItem{})
+	gob.Register(&TagDependency{})
+	gob.Register(&FileDependency{})
+	gob.Register(&ExpressionDependency{})
+	gob.Register(&KeyDependency{})
+}
+
+// The value stored with the dependencies and the evaluated data of them.
+type dependentItem struct {
+	Value        interface{}
+	Dependencies []CacheDependency
+	Data         []string
+}
+
+// Returns a boolean indicating whether any dependency changed, the dependency which fails to evaluate is regarded as changed.
+func (this dependentItem) isChanged(cache Cache) bool {
+	if len(this.Dependencies) != len(this.Data) {
+		return true
+	}
+	for i, dependency := range this.Dependencies {
+		data, err := dependency.Evaluate(cache)
+		if (err != nil) || (data != this.Data[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the value with the dependencies, it is used by the cache drivers.
+func setWithDependency(cache Cache, key string, value interface{}, ttl time.Duration, dependencies []CacheDependency) error {
+	if len(dependencies) == 0 {
+		return cache.Set(key, value, ttl)
+	}
+
+	item := dependentItem{
+		Value:        value,
+		Dependencies: dependencies,
+		Data:         make([]string, len(dependencies)),
+	}
+	for i, dependency := range dependencies {
+		data, err := dependency.Evaluate(cache)
+		if err != nil {
+			return err
+		}
+		item.Data[i] = data
+	}
+	return cache.Set(key, item, ttl)
+}
+
+// Resolve the value read by the cache drivers, the value stored by Remember is unwrapped.
+func resolveCacheValue(cache Cache, key string, value interface{}) (interface{}, error) {
+	value, err := resolveCacheItem(cache, key, value)
+	if err != nil {
+		return nil, err
+	}
+	return getRememberedValue(value), nil
+}
+
+// Resolve the item read by the cache drivers, the value which depends on the changed dependencies
+// will be deleted, and ErrCacheMiss will be returned.
+func resolveCacheItem(cache Cache, key string, value interface{}) (interface{}, error) {
+	item, ok := value.(dependentItem)
+	if !ok {
+		return value, nil
+	}
+	if item.isChanged(cache) {
+		cache.Delete(key)
+		return nil, ErrCacheMiss
+	}
+	return item.Value, nil
+}
+
+// The prefix of the keys which store the versions of tags.
+const cacheTagPrefix = "cheetah:tag:"
+
+func generateTagVersion() string {
+	return stringutil.GenerateRandomString(16)
+}
+
+// Invalidate the values which depend on the tags, by changing the versions of the tags.
+func invalidateTags(cache Cache, tags []string) error {
+	for _, tag := range tags {
+		if err := cache.Set(cacheTagPrefix+tag, generateTagVersion(), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tag dependency, the value will be invalidated when any tag is invalidated by Cache.InvalidateTags.
+type TagDependency struct {
+	Tags []string
+}
+
+func NewTagDependency(tags ...string) *TagDependency {
+	return &TagDependency{Tags: tags}
+}
+
+// Returns the versions of the tags, the missing version will be generated,
+// so that the values are invalidated if the version was evicted.
+func (this *TagDependency) Evaluate(cache Cache) (string, error) {
+	keys := make([]string, len(this.Tags))
+	for i, tag := range this.Tags {
+		keys[i] = cacheTagPrefix + tag
+	}
+	values, err := cache.GetMulti(keys...)
+	if err != nil {
+		return "", err
+	}
+
+	versions := make([]string, len(keys))
+	for i, key := range keys {
+		if version, ok := values[key].(string); ok {
+			versions[i] = version
+			continue
+		}
+		versions[i] = generateTagVersion()
+		if err = cache.Set(key, versions[i], 0); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(versions, ","), nil
+}
+
+// File dependency, the value will be invalidated when the file's modification time changed.
+type FileDependency struct {
+	Filename string
+}
+
+func NewFileDependency(filename string) *FileDependency {
+	return &FileDependency{Filename: filename}
+}
+
+func (this *FileDependency) Evaluate(cache Cache) (string, error) {
+	info, err := os.Stat(this.Filename)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(info.ModTime().UnixNano(), 10), nil
+}
+
+var (
+	cacheExpressions      = make(map[string]func() string)
+	cacheExpressionsMutex sync.RWMutex
+)
+
+// Register the expression which is used by ExpressionDependency.
+// The expressions are referenced by name, so that the dependencies can be stored in the redis and file caches,
+// the expressions must be registered by every process.
+func RegisterCacheExpression(name string, expression func() string) {
+	cacheExpressionsMutex.Lock()
+	defer cacheExpressionsMutex.Unlock()
+	cacheExpressions[name] = expression
+}
+
+// Expression dependency, the value will be invalidated when the result of the registered expression changed.
+type ExpressionDependency struct {
+	Name string
+}
+
+func NewExpressionDependency(name string) *ExpressionDependency {
+	return &ExpressionDependency{Name: name}
+}
+
+func (this *ExpressionDependency) Evaluate(cache Cache) (string, error) {
+	cacheExpressionsMutex.RLock()
+	expression, ok := cacheExpressions[this.Name]
+	cacheExpressionsMutex.RUnlock()
+	if !ok {
+		return "", errors.New("The cache expression is not registered: " + this.Name)
+	}
+	return expression(), nil
+}
+
+// Key dependency, the value will be invalidated when the value of the key changed,
+// such as a version number increased by Cache.Incr.
+type KeyDependency struct {
+	Key string
+}
+
+func NewKeyDependency(key string) *KeyDependency {
+	return &KeyDependency{Key: key}
+}
+
+// The missing key is evaluated as empty string.
+func (this *KeyDependency) Evaluate(cache Cache) (string, error) {
+	value, err := cache.Get(this.Key)
+	if err == ErrCacheMiss {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// File cache, every item is stored in a file which is named as the SHA1 of the key,
+// the files are distributed in 256 sub-directories.
+type FileCache struct {
+	cacheRemember
+	dir   string
+	mutex sync.Mutex // it is used to make Incr atomic in the process.
+}
+
+// Create a file cache, the directory will be created if it does not exist.
+func NewFileCache(dir string) *FileCache {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		panic(err)
+	}
+	return &FileCache{
+		dir: dir,
+	}
+}
+
+func (this *FileCache) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return path.Join(this.dir, name[:2], name)
+}
+
+// The file consists of the expiration time(unix nano, 8 bytes, 0 means never) and the encoded value.
+func (this *FileCache) read(key string) (interface{}, time.Time, error) {
+	content, err := ioutil.ReadFile(this.filename(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, ErrCacheMiss
+		}
+		return nil, time.Time{}, err
+	}
+	if len(content) < 8 {
+		return nil, time.Time{}, ErrCacheMiss
+	}
+
+	expire := time.Time{}
+	if timestamp := int64(binary.BigEndian.Uint64(content[:8])); timestamp > 0 {
+		expire = time.Unix(0, timestamp)
+		if time.Now().After(expire) {
+			os.Remove(this.filename(key))
+			return nil, time.Time{}, ErrCacheMiss
+		}
+	}
+
+	value, err := decodeCacheValue(content[8:])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return value, expire, nil
+}
+
+func (this *FileCache) write(key string, value interface{}, expire time.Time) error {
+	data, err := encodeCacheValue(value)
+	if err != nil {
+		return err
+	}
+
+	content := make([]byte, 8, 8+len(data))
+	if !expire.IsZero() {
+		binary.BigEndian.PutUint64(content, uint64(expire.UnixNano()))
+	}
+	content = append(content, data...)
+
+	filename := this.filename(key)
+	if err = os.MkdirAll(path.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	// Write into a temporary file first, and then rename it, so that the file is always complete.
+	file, err := ioutil.TempFile(path.Dir(filename), "tmp_")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+func (this *FileCache) Get(key string) (interface{}, error) {
+	value, err := this.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return getRememberedValue(value), nil
+}
+
+// Get the item without unwrapping the value stored by Remember.
+func (this *FileCache) getItem(key string) (interface{}, error) {
+	value, _, err := this.read(key)
+	if err != nil {
+		return nil, err
+	}
+	return resolveCacheItem(this, key, value)
+}
+
+func (this *FileCache) GetMulti(keys ...string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		value, _, err := this.read(key)
+		if err == ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if value, err = resolveCacheValue(this, key, value); err == nil {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (this *FileCache) Set(key string, value interface{}, ttl time.Duration) error {
+	expire := time.Time{}
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	return this.write(key, value, expire)
+}
+
+func (this *FileCache) SetWithDependency(key string, value interface{}, ttl time.Duration, dependencies ...CacheDependency) error {
+	return setWithDependency(this, key, value, ttl, dependencies)
+}
+
+func (this *FileCache) InvalidateTags(tags ...string) error {
+	return invalidateTags(this, tags)
+}
+
+func (this *FileCache) Delete(key string) error {
+	err := os.Remove(this.filename(key))
+	if (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (this *FileCache) Has(key string) (bool, error) {
+	_, _, err := this.read(key)
+	if err == ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// The TTL of the existing key will be kept, it is only atomic in the process.
+func (this *FileCache) Incr(key string, delta int64) (int64, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	value, expire, err := this.read(key)
+	if err == ErrCacheMiss {
+		return delta, this.write(key, delta, time.Time{})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, current, err := incrCacheValue(value, delta)
+	if err != nil {
+		return 0, err
+	}
+	return current, this.write(key, value, expire)
+}
+
+func (this *FileCache) Remember(key string, ttl time.Duration, fn RememberFunc) (interface{}, error) {
+	return this.remember(this, key, ttl, fn)
+}
@@ -0,0 +1,252 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/HeadwindFly/cheetah/utils/string"
+	"time"
+)
+
+// The max retries of Incr when the key is modified concurrently.
+const redisIncrRetries = 10
+
+// Redis cache, the keys are prefixed with the prefix.
+type RedisCache struct {
+	cacheRemember
+	getConn         func() redisConn
+	prefix          string
+	distributedLock bool
+}
+
+func NewRedisCache(getConn func() redisConn, prefix string) *RedisCache {
+	return &RedisCache{
+		getConn: getConn,
+		prefix:  prefix,
+	}
+}
+
+func (this *RedisCache) key(key string) string {
+	return this.prefix + key
+}
+
+func (this *RedisCache) Get(key string) (interface{}, error) {
+	value, err := this.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+	return getRememberedValue(value), nil
+}
+
+// Get the item without unwrapping the value stored by Remember.
+func (this *RedisCache) getItem(key string) (interface{}, error) {
+	conn := this.getConn()
+	defer conn.Close()
+
+	reply, err := conn.Do("GET", this.key(key))
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrCacheMiss
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, errors.New("Unexpected reply of GET.")
+	}
+	value, err := decodeCacheValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return resolveCacheItem(this, key, value)
+}
+
+func (this *RedisCache) GetMulti(keys ...string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, this.key(key))
+	}
+
+	conn := this.getConn()
+	defer conn.Close()
+
+	reply, err := conn.Do("MGET", args...)
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || (len(replies) != len(keys)) {
+		return nil, errors.New("Unexpected reply of MGET.")
+	}
+	for i, reply := range replies {
+		data, ok := reply.([]byte)
+		if !ok {
+			continue
+		}
+		value, err := decodeCacheValue(data)
+		if err != nil {
+			continue
+		}
+		if value, err = resolveCacheValue(this, keys[i], value); err == nil {
+			values[keys[i]] = value
+		}
+	}
+	return values, nil
+}
+
+func (this *RedisCache) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := encodeCacheValue(value)
+	if err != nil {
+		return err
+	}
+
+	conn := this.getConn()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", getRedisSetArgs(this.key(key), data, ttl)...)
+	return err
+}
+
+// Get the arguments of SET, the TTL less than a millisecond is rounded up, because redis refuses zero PX.
+func getRedisSetArgs(key string, data []byte, ttl time.Duration) []interface{} {
+	if ttl <= 0 {
+		return []interface{}{key, data}
+	}
+	px := int64(ttl / time.Millisecond)
+	if px == 0 {
+		px = 1
+	}
+	return []interface{}{key, data, "PX", px}
+}
+
+func (this *RedisCache) SetWithDependency(key string, value interface{}, ttl time.Duration, dependencies ...CacheDependency) error {
+	return setWithDependency(this, key, value, ttl, dependencies)
+}
+
+func (this *RedisCache) InvalidateTags(tags ...string) error {
+	return invalidateTags(this, tags)
+}
+
+func (this *RedisCache) Delete(key string) error {
+	conn := this.getConn()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", this.key(key))
+	return err
+}
+
+func (this *RedisCache) Has(key string) (bool, error) {
+	conn := this.getConn()
+	defer conn.Close()
+
+	reply, err := conn.Do("EXISTS", this.key(key))
+	if err != nil {
+		return false, err
+	}
+	count, ok := reply.(int64)
+	return ok && (count > 0), nil
+}
+
+// The integer value is encoded like the other values, so that the drivers behave the same,
+// it is increased by an optimistic transaction, and the TTL of the existing key will be kept.
+func (this *RedisCache) Incr(key string, delta int64) (int64, error) {
+	conn := this.getConn()
+	defer conn.Close()
+
+	key = this.key(key)
+	for i := 0; i < redisIncrRetries; i++ {
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return 0, err
+		}
+		reply, err := conn.Do("GET", key)
+		if err != nil {
+			return 0, err
+		}
+
+		var value interface{} = delta
+		result := delta
+		ttl := time.Duration(0)
+		if data, ok := reply.([]byte); ok {
+			current, err := decodeCacheValue(data)
+			if err != nil {
+				return 0, err
+			}
+			if value, result, err = incrCacheValue(current, delta); err != nil {
+				return 0, err
+			}
+
+			reply, err = conn.Do("PTTL", key)
+			if err != nil {
+				return 0, err
+			}
+			if px, ok := reply.(int64); ok && (px > 0) {
+				ttl = time.Duration(px) * time.Millisecond
+			}
+		}
+
+		data, err := encodeCacheValue(value)
+		if err != nil {
+			return 0, err
+		}
+		if _, err = conn.Do("MULTI"); err != nil {
+			return 0, err
+		}
+		if _, err = conn.Do("SET", getRedisSetArgs(key, data, ttl)...); err != nil {
+			return 0, err
+		}
+		// The reply of EXEC is nil if the key was modified by others, then retry.
+		if reply, err = conn.Do("EXEC"); err != nil {
+			return 0, err
+		}
+		if reply != nil {
+			return result, nil
+		}
+	}
+	return 0, errors.New("Unable to increase the value, the key was modified concurrently.")
+}
+
+// Enable the distributed lock of Remember, so that only one process computes the value of the key,
+// and the others wait for it.
+func (this *RedisCache) SetDistributedLock(enabled bool) {
+	this.distributedLock = enabled
+}
+
+func (this *RedisCache) Remember(key string, ttl time.Duration, fn RememberFunc) (interface{}, error) {
+	if this.distributedLock {
+		return this.remember(this, key, ttl, fn)
+	}
+	return this.remember(redisCacheWithoutLock{this}, key, ttl, fn)
+}
+
+// It hides the lock method of RedisCache, it is used when the distributed lock is disabled.
+type redisCacheWithoutLock struct {
+	rememberCache
+}
+
+// The script releases the lock only if it is held by the token.
+const redisUnlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+func (this *RedisCache) lock(key string, ttl time.Duration) (func(), bool) {
+	lockKey := this.key("lock:" + key)
+	token := base64.RawURLEncoding.EncodeToString(stringutil.GenerateRandomByte(16))
+
+	conn := this.getConn()
+	reply, err := conn.Do("SET", lockKey, token, "NX", "PX", int64(ttl/time.Millisecond))
+	conn.Close()
+	if (err != nil) || (reply == nil) {
+		return nil, false
+	}
+
+	return func() {
+		conn := this.getConn()
+		defer conn.Close()
+		conn.Do("EVAL", redisUnlockScript, 1, lockKey, token)
+	}, true
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/gob"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The function which computes the value of Cache.Remember.
+type RememberFunc func() (interface{}, error)
+
+// The statistics of Cache.Remember.
+type CacheStats struct {
+	Hits   uint64 // the number of fresh values returned.
+	Misses uint64 // the number of values computed synchronously.
+	Stale  uint64 // the number of stale values returned while being revalidated in background.
+}
+
+// The value stored by Remember, it remembers when it becomes stale.
+type rememberItem struct {
+	Value interface{}
+	Fresh int64 // the value is fresh before this time(unix nano), 0 means always.
+}
+
+func (this rememberItem) isFresh() bool {
+	return (this.Fresh == 0) || (time.Now().UnixNano() < this.Fresh)
+}
+
+// Get the value from the item stored by Remember, the other values are returned as they are.
+func getRememberedValue(value interface{}) interface{} {
+	if item, ok := value.(rememberItem); ok {
+		return item.Value
+	}
+	return value
+}
+
+func init() {
+	gob.Register(rememberItem{})
+}
+
+// The cache which is used by Remember, the items are read without unwrapping the values stored by Remember.
+type rememberCache interface {
+	Cache
+	getItem(key string) (interface{}, error)
+}
+
+// The cache which supports the distributed lock, the lock will be released by the returned function,
+// false will be returned if the lock is held by the others.
+type cacheLocker interface {
+	lock(key string, ttl time.Duration) (unlock func(), ok bool)
+}
+
+// The time to hold the distributed lock while computing the value.
+const rememberLockTtl = 10 * time.Second
+
+// The interval of polling the value when the distributed lock is held by the others.
+const rememberPollInterval = 50 * time.Millisecond
+
+// The call of computing the value of the key.
+type rememberCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// It implements Cache.Remember, it is embedded in the cache drivers.
+// The concurrent computations of the same key are deduplicated in the process.
+type cacheRemember struct {
+	staleTtl int64 // time.Duration
+	mutex    sync.Mutex
+	calls    map[string]*rememberCall
+	hits     uint64
+	misses   uint64
+	stale    uint64
+}
+
+// Set the time to serve the stale values after they expired, the stale value will be returned
+// immediately while it is being recomputed in background. 0 disabled it.
+func (this *cacheRemember) SetStaleTtl(ttl time.Duration) {
+	atomic.StoreInt64(&this.staleTtl, int64(ttl))
+}
+
+// Get the statistics of Remember.
+func (this *cacheRemember) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&this.hits),
+		Misses: atomic.LoadUint64(&this.misses),
+		Stale:  atomic.LoadUint64(&this.stale),
+	}
+}
+
+func (this *cacheRemember) remember(cache rememberCache, key string, ttl time.Duration, fn RememberFunc) (interface{}, error) {
+	if value, err := cache.getItem(key); err == nil {
+		item, ok := value.(rememberItem)
+		if !ok {
+			// The value was not stored by Remember.
+			atomic.AddUint64(&this.hits, 1)
+			return value, nil
+		}
+		if item.isFresh() {
+			atomic.AddUint64(&this.hits, 1)
+			return item.Value, nil
+		}
+
+		atomic.AddUint64(&this.stale, 1)
+		go this.do(key, func() (interface{}, error) {
+			return this.compute(cache, key, ttl, fn, false)
+		})
+		return item.Value, nil
+	}
+
+	atomic.AddUint64(&this.misses, 1)
+	return this.do(key, func() (interface{}, error) {
+		return this.compute(cache, key, ttl, fn, true)
+	})
+}
+
+// Invoke fn, the concurrent calls of the same key will wait for the first call and share its result.
+func (this *cacheRemember) do(key string, fn RememberFunc) (interface{}, error) {
+	this.mutex.Lock()
+	if this.calls == nil {
+		this.calls = make(map[string]*rememberCall)
+	}
+	if call, ok := this.calls[key]; ok {
+		this.mutex.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &rememberCall{}
+	call.wg.Add(1)
+	this.calls[key] = call
+	this.mutex.Unlock()
+
+	defer func() {
+		call.wg.Done()
+		this.mutex.Lock()
+		delete(this.calls, key)
+		this.mutex.Unlock()
+	}()
+
+	call.value, call.err = fn()
+	return call.value, call.err
+}
+
+// Compute the value and store it.
+// If the cache supports the distributed lock and the lock is held by the others, it waits for
+// the value computed by the others if wait is true, otherwise it gives up.
+func (this *cacheRemember) compute(cache rememberCache, key string, ttl time.Duration, fn RememberFunc, wait bool) (interface{}, error) {
+	if locker, ok := cache.(cacheLocker); ok {
+		unlock, locked := locker.lock(key, rememberLockTtl)
+		if locked {
+			defer unlock()
+		} else if !wait {
+			return nil, nil
+		} else if value, ok := this.wait(cache, key); ok {
+			return value, nil
+		}
+	}
+
+	value, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	item := rememberItem{Value: value}
+	staleTtl := time.Duration(atomic.LoadInt64(&this.staleTtl))
+	if ttl > 0 {
+		item.Fresh = time.Now().Add(ttl).UnixNano()
+		ttl += staleTtl
+	}
+	if err = cache.Set(key, item, ttl); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Wait for the fresh value computed by the lock holder, false will be returned if the lock expired.
+func (this *cacheRemember) wait(cache rememberCache, key string) (interface{}, bool) {
+	deadline := time.Now().Add(rememberLockTtl)
+	for time.Now().Before(deadline) {
+		time.Sleep(rememberPollInterval)
+		if value, err := cache.getItem(key); err == nil {
+			if item, ok := value.(rememberItem); !ok {
+				return value, true
+			} else if item.isFresh() {
+				return item.Value, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,448 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testCache(t *testing.T, cache Cache) {
+	if _, err := cache.Get("missing"); err != ErrCacheMiss {
+		t.Errorf("Get(\"missing\") should return ErrCacheMiss.\nthe wrong result: %v", err)
+	}
+
+	cache.Set("name", "cheetah", 0)
+	if value, err := cache.Get("name"); (err != nil) || (value != "cheetah") {
+		t.Errorf("Get(\"name\") should be \"cheetah\".\nthe wrong result: %v %v", value, err)
+	}
+	if ok, _ := cache.Has("name"); !ok {
+		t.Errorf("Has(\"name\") should be true.")
+	}
+
+	cache.Set("expired", "value", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if ok, _ := cache.Has("expired"); ok {
+		t.Errorf("The expired key should not exist.")
+	}
+
+	cache.Incr("counter", 2)
+	if value, _ := cache.Incr("counter", 3); value != 5 {
+		t.Errorf("Incr(\"counter\", 3) should be 5.\nthe wrong result: %d", value)
+	}
+
+	// The value which was set by Set can be increased.
+	cache.Set("hits", 10, 0)
+	if value, err := cache.Incr("hits", 1); (err != nil) || (value != 11) {
+		t.Errorf("Incr(\"hits\", 1) should be 11.\nthe wrong result: %d %v", value, err)
+	}
+
+	values, _ := cache.GetMulti("name", "counter", "missing")
+	if (len(values) != 2) || (values["name"] != "cheetah") || (values["counter"] != int64(5)) {
+		t.Errorf("GetMulti should return the existing keys.\nthe wrong result: %v", values)
+	}
+
+	cache.Delete("name")
+	if ok, _ := cache.Has("name"); ok {
+		t.Errorf("The deleted key should not exist.")
+	}
+
+	// The value stored by Remember is read like the other values.
+	cache.Remember("remembered", time.Minute, func() (interface{}, error) { return 1, nil })
+	if value, err := cache.Get("remembered"); (err != nil) || (value != 1) {
+		t.Errorf("Get(\"remembered\") should be 1.\nthe wrong result: %v %v", value, err)
+	}
+	if values, _ := cache.GetMulti("remembered"); values["remembered"] != 1 {
+		t.Errorf("GetMulti should return the remembered value.\nthe wrong result: %v", values)
+	}
+	if value, err := cache.Incr("remembered", 1); (err != nil) || (value != 2) {
+		t.Errorf("Incr(\"remembered\", 1) should be 2.\nthe wrong result: %d %v", value, err)
+	}
+	if value, _ := cache.Remember("remembered", time.Minute, func() (interface{}, error) { return 0, nil }); value != int64(2) {
+		t.Errorf("The increased value should be remembered.\nthe wrong result: %v", value)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, NewMemoryCache(0))
+}
+
+func TestRedisCache(t *testing.T) {
+	conn := newFakeRedisConn()
+	testCache(t, NewRedisCache(func() redisConn { return conn }, "cheetah:"))
+}
+
+// The in-memory redis connection, it supports the commands which are used by the redis cache.
+type fakeRedisConn struct {
+	data    map[string][]byte
+	expire  map[string]time.Time
+	queued  [][]interface{}
+	inMulti bool
+}
+
+func newFakeRedisConn() *fakeRedisConn {
+	return &fakeRedisConn{
+		data:   make(map[string][]byte),
+		expire: make(map[string]time.Time),
+	}
+}
+
+func (this *fakeRedisConn) get(key string) ([]byte, bool) {
+	if expire, ok := this.expire[key]; ok && time.Now().After(expire) {
+		delete(this.data, key)
+		delete(this.expire, key)
+	}
+	data, ok := this.data[key]
+	return data, ok
+}
+
+func (this *fakeRedisConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if this.inMulti && (commandName != "EXEC") {
+		this.queued = append(this.queued, append([]interface{}{commandName}, args...))
+		return "QUEUED", nil
+	}
+
+	switch commandName {
+	case "WATCH":
+		return "OK", nil
+	case "MULTI":
+		this.inMulti = true
+		return "OK", nil
+	case "EXEC":
+		this.inMulti = false
+		replies := make([]interface{}, 0, len(this.queued))
+		for _, command := range this.queued {
+			reply, err := this.Do(command[0].(string), command[1:]...)
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, reply)
+		}
+		this.queued = nil
+		return replies, nil
+	case "GET":
+		if data, ok := this.get(args[0].(string)); ok {
+			return data, nil
+		}
+		return nil, nil
+	case "MGET":
+		replies := make([]interface{}, 0, len(args))
+		for _, key := range args {
+			if data, ok := this.get(key.(string)); ok {
+				replies = append(replies, data)
+			} else {
+				replies = append(replies, nil)
+			}
+		}
+		return replies, nil
+	case "SET":
+		key := args[0].(string)
+		if (len(args) > 2) && (args[2] == "NX") {
+			if _, ok := this.get(key); ok {
+				return nil, nil
+			}
+			args = append(args[:2], args[3:]...)
+		}
+		switch value := args[1].(type) {
+		case []byte:
+			this.data[key] = value
+		default:
+			this.data[key] = []byte(fmt.Sprint(value))
+		}
+		delete(this.expire, key)
+		if (len(args) > 3) && (args[2] == "PX") {
+			px := args[3].(int64)
+			if px <= 0 {
+				return nil, errors.New("ERR invalid expire time in 'set' command")
+			}
+			this.expire[key] = time.Now().Add(time.Duration(px) * time.Millisecond)
+		}
+		return "OK", nil
+	case "PTTL":
+		key := args[0].(string)
+		if _, ok := this.get(key); !ok {
+			return int64(-2), nil
+		}
+		if expire, ok := this.expire[key]; ok {
+			return int64(time.Until(expire) / time.Millisecond), nil
+		}
+		return int64(-1), nil
+	case "EXISTS":
+		if _, ok := this.get(args[0].(string)); ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "DEL":
+		key := args[0].(string)
+		_, ok := this.get(key)
+		delete(this.data, key)
+		delete(this.expire, key)
+		if ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	}
+	return nil, errors.New("ERR unknown command " + commandName)
+}
+
+func (this *fakeRedisConn) Close() error {
+	return nil
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Get("a")
+	cache.Set("c", 3, 0)
+
+	if ok, _ := cache.Has("b"); ok {
+		t.Errorf("The least recently used key \"b\" should be evicted.")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("The number of items should be 2.\nthe wrong result: %d", cache.Len())
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	testCache(t, NewFileCache(t.TempDir()))
+}
+
+func TestCacheRemember(t *testing.T) {
+	cache := NewMemoryCache(10)
+
+	var calls int32
+	fn := func() (interface{}, error) {
+		// Wait until all the callers missed the key, so that they wait for this call.
+		if atomic.AddInt32(&calls, 1) == 1 {
+			for cache.Stats().Misses < 10 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := cache.Remember("key", time.Minute, fn); (err != nil) || (value != "value") {
+				t.Errorf("Remember(\"key\") should be \"value\".\nthe wrong result: %v %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("The fn should be called once.\nthe wrong result: %d", calls)
+	}
+
+	if value, _ := cache.Remember("key", time.Minute, fn); value != "value" {
+		t.Errorf("The remembered value should be \"value\".\nthe wrong result: %v", value)
+	}
+	if stats := cache.Stats(); (stats.Hits != 1) || (stats.Misses != 10) {
+		t.Errorf("The stats should be 1 hit and 10 misses.\nthe wrong result: %+v", stats)
+	}
+
+	if _, err := cache.Remember("error", time.Minute, func() (interface{}, error) {
+		return nil, errors.New("failed")
+	}); err == nil {
+		t.Errorf("The error of fn should be returned.")
+	}
+	if ok, _ := cache.Has("error"); ok {
+		t.Errorf("The failed value should not be stored.")
+	}
+}
+
+func TestCacheRememberStale(t *testing.T) {
+	cache := NewMemoryCache(10)
+	cache.SetStaleTtl(time.Minute)
+
+	cache.Remember("key", time.Millisecond, func() (interface{}, error) { return 1, nil })
+	time.Sleep(5 * time.Millisecond)
+
+	refreshed := make(chan struct{})
+	value, _ := cache.Remember("key", time.Minute, func() (interface{}, error) {
+		defer close(refreshed)
+		return 2, nil
+	})
+	if value != 1 {
+		t.Errorf("The stale value should be 1.\nthe wrong result: %v", value)
+	}
+	<-refreshed
+	time.Sleep(5 * time.Millisecond)
+
+	if value, _ := cache.Remember("key", time.Minute, func() (interface{}, error) { return 3, nil }); value != 2 {
+		t.Errorf("The refreshed value should be 2.\nthe wrong result: %v", value)
+	}
+	if stats := cache.Stats(); stats.Stale != 1 {
+		t.Errorf("The stats should be 1 stale.\nthe wrong result: %+v", stats)
+	}
+}
+
+func testCacheDependency(t *testing.T, cache Cache) {
+	cache.SetWithDependency("user:1:profile", "profile", 0, NewTagDependency("user:1"))
+	cache.SetWithDependency("user:1:posts", "posts", 0, NewTagDependency("user:1", "posts"))
+	cache.SetWithDependency("user:2:profile", "profile", 0, NewTagDependency("user:2"))
+
+	if value, err := cache.Get("user:1:profile"); (err != nil) || (value != "profile") {
+		t.Fatalf("Get(\"user:1:profile\") should be \"profile\".\nthe wrong result: %v %v", value, err)
+	}
+
+	cache.InvalidateTags("user:1")
+	for _, key := range []string{"user:1:profile", "user:1:posts"} {
+		if _, err := cache.Get(key); err != ErrCacheMiss {
+			t.Errorf("The %s should be invalidated.\nthe wrong result: %v", key, err)
+		}
+	}
+	if values, _ := cache.GetMulti("user:1:profile", "user:2:profile"); (len(values) != 1) || (values["user:2:profile"] != "profile") {
+		t.Errorf("Only the user:2:profile should exist.\nthe wrong result: %v", values)
+	}
+
+	cache.SetWithDependency("list", "list", 0, NewKeyDependency("list:version"))
+	if _, err := cache.Get("list"); err != nil {
+		t.Errorf("Get(\"list\") should succeed.\nthe wrong result: %v", err)
+	}
+	cache.Incr("list:version", 1)
+	if _, err := cache.Get("list"); err != ErrCacheMiss {
+		t.Errorf("The value should be invalidated by the key version.\nthe wrong result: %v", err)
+	}
+
+	version := "1"
+	RegisterCacheExpression("test.version", func() string { return version })
+	cache.SetWithDependency("expression", "expression", 0, NewExpressionDependency("test.version"))
+	if _, err := cache.Get("expression"); err != nil {
+		t.Errorf("Get(\"expression\") should succeed.\nthe wrong result: %v", err)
+	}
+	version = "2"
+	if _, err := cache.Get("expression"); err != ErrCacheMiss {
+		t.Errorf("The value should be invalidated by the expression.\nthe wrong result: %v", err)
+	}
+}
+
+func TestMemoryCacheDependency(t *testing.T) {
+	testCacheDependency(t, NewMemoryCache(0))
+}
+
+func TestRedisCacheDependency(t *testing.T) {
+	conn := newFakeRedisConn()
+	testCacheDependency(t, NewRedisCache(func() redisConn { return conn }, "cheetah:"))
+}
+
+func TestFileCacheDependency(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "config")
+	ioutil.WriteFile(filename, []byte("config"), 0600)
+
+	cache := NewFileCache(path.Join(dir, "cache"))
+	testCacheDependency(t, cache)
+
+	cache.SetWithDependency("config", "config", 0, NewFileDependency(filename))
+	if _, err := cache.Get("config"); err != nil {
+		t.Errorf("Get(\"config\") should succeed.\nthe wrong result: %v", err)
+	}
+	modified := time.Now().Add(time.Hour)
+	os.Chtimes(filename, modified, modified)
+	if _, err := cache.Get("config"); err != ErrCacheMiss {
+		t.Errorf("The value should be invalidated by the file.\nthe wrong result: %v", err)
+	}
+}
+
+func TestCacheFragment(t *testing.T) {
+	cache := App.Cache
+	defer func() {
+		App.Cache = cache
+	}()
+	App.Cache = NewMemoryCache(0)
+
+	renders := 0
+	render := func() string {
+		renders++
+		return "<nav>" + strconv.Itoa(renders) + "</nav>"
+	}
+
+	for i := 0; i < 2; i++ {
+		controller := &WebController{}
+		if html := controller.CacheFragment("menu", "menu", time.Minute, render, NewTagDependency("menu")); html != "<nav>1</nav>" {
+			t.Errorf("The cached fragment should be \"<nav>1</nav>\".\nthe wrong result: %s", html)
+		}
+		if context := controller.getFragmentContext(); context["menu"] != template.HTML("<nav>1</nav>") {
+			t.Errorf("The fragment should be exposed to the view.\nthe wrong result: %v", context)
+		}
+	}
+
+	App.Cache.InvalidateTags("menu")
+	if html := (&WebController{}).CacheFragment("menu", "menu", time.Minute, render); html != "<nav>2</nav>" {
+		t.Errorf("The fragment should be rendered again.\nthe wrong result: %s", html)
+	}
+}
+
+func TestCacheBlock(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	App.Cache = NewMemoryCache(0)
+	App.hosts = Hosts{"www.example.com": &Host{}, "admin.example.com": &Host{}}
+
+	newController := func(host string, theme *Theme) *WebController {
+		return &WebController{Context: &Context{Request: httptest.NewRequest("GET", "http://"+host+"/", nil)}, Theme: theme}
+	}
+	cases := []struct {
+		engine ViewEngine
+		data   string
+	}{
+		{NewHtmlEngine(), `{{cache "menu" "1m" "menu" . "menu"}}{{define "menu"}}<nav>{{.n}}</nav>{{end}}`},
+		{NewMustacheEngine(), `{{#cache "mustache-menu" "1m" "menu"}}<nav>{{n}}</nav>{{/cache}}`},
+	}
+	for _, c := range cases {
+		App.Cache.InvalidateTags("menu")
+		for i := 0; i < 2; i++ {
+			controller := newController("www.example.com", nil)
+			html, err := c.engine.Render(c.data, map[string]interface{}{"n": i + 1}, controller.getViewScope())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if html != "<nav>1</nav>" {
+				t.Errorf("The block should be cached as \"<nav>1</nav>\".\nthe wrong result: %s", html)
+			}
+		}
+
+		// The blocks of the other hosts and themes are cached separately.
+		for _, controller := range []*WebController{newController("admin.example.com", nil), newController("www.example.com", NewTheme("dark", nil))} {
+			html, err := c.engine.Render(c.data, map[string]interface{}{"n": 3}, controller.getViewScope())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if html != "<nav>3</nav>" {
+				t.Errorf("The block should be cached by the host and theme.\nthe wrong result: %s", html)
+			}
+		}
+
+		App.Cache.InvalidateTags("menu")
+		html, err := c.engine.Render(c.data, map[string]interface{}{"n": 4}, newController("www.example.com", nil).getViewScope())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if html != "<nav>4</nav>" {
+			t.Errorf("The block should be rendered again after invalidating the tag.\nthe wrong result: %s", html)
+		}
+	}
+
+	if _, err := NewHtmlEngine().Render(`{{cache "menu" "forever" "menu" .}}{{define "menu"}}{{end}}`, newController("www.example.com", nil).getViewScope()); err == nil {
+		t.Errorf("The error of the invalid TTL should be returned.")
+	}
+}
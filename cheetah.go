@@ -4,11 +4,14 @@
 package cheetah
 
 import (
+	"fmt"
 	"github.com/HeadwindFly/cheetah/utils/string"
 	"github.com/go-language/session"
 	"github.com/julienschmidt/httprouter"
+	"io/fs"
 	"net/http"
 	"reflect"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
@@ -33,8 +36,11 @@ func NewHost(host string) *Host {
 	return App.newHost(host)
 }
 
-func generateRouteHandle(route string, controllerType reflect.Type, info *ControllerInfo) httprouter.Handle {
+func generateRouteHandle(route string, controllerType reflect.Type, routeInfo *ControllerInfo) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// Copy the controller's info, the log is request-scoped.
+		_info := *routeInfo
+		info := &_info
 		if App.Config.enableLog {
 			info.Log = App.Logger.NewLog()
 			defer info.Log.Flush()
@@ -44,6 +50,18 @@ func generateRouteHandle(route string, controllerType reflect.Type, info *Contro
 
 		v := reflect.New(controllerType)
 
+		if App.mode == ModeDev {
+			recordDebugInfo(r, info, v)
+		}
+
+		// The panic will be recovered before flushing the log.
+		responded := false
+		defer func() {
+			if err := recover(); err != nil {
+				recoverController(v, info, w, r, err, responded)
+			}
+		}()
+
 		initArgs := []reflect.Value{
 			reflect.ValueOf(info),
 			reflect.ValueOf(&w),
@@ -52,9 +70,9 @@ func generateRouteHandle(route string, controllerType reflect.Type, info *Contro
 		initMethod := v.MethodByName("Init")
 		initMethod.Call(initArgs)
 
+		canInvokeAction := true
 		beforeActionMethod := v.MethodByName("BeforeAction")
 		beforeResult := beforeActionMethod.Call([]reflect.Value{})
-		canInvokeAction := true
 		for _, value := range beforeResult {
 			if _value, ok := value.Interface().(bool); ok {
 				canInvokeAction = _value
@@ -62,6 +80,23 @@ func generateRouteHandle(route string, controllerType reflect.Type, info *Contro
 			break
 		}
 
+		// execute the filters after BeforeAction, so that the checks of BeforeAction(such as authentication)
+		// can not be bypassed by the filters, such as the page cache.
+		var controller *WebController
+		executedFilters := 0
+		if canInvokeAction && (len(info.Filters) > 0) {
+			if controller = getWebController(v); controller == nil {
+				panic("The filters require the controller to embed WebController.")
+			}
+			for _, filter := range info.Filters {
+				executedFilters++
+				if !filter.BeforeAction(controller) {
+					canInvokeAction = false
+					break
+				}
+			}
+		}
+
 		if canInvokeAction {
 			params := []reflect.Value{}
 			if len(info.Params) > 0 {
@@ -88,15 +123,112 @@ func generateRouteHandle(route string, controllerType reflect.Type, info *Contro
 
 			// invoke the action.
 			actionMethod := v.MethodByName(info.ActionFullName)
-			actionMethod.Call(params)
+			actionResult := actionMethod.Call(params)
+
+			// pass the action's first return value to AfterAction, nil if the action returns nothing.
+			var result interface{}
+			if len(actionResult) > 0 {
+				result = actionResult[0].Interface()
+			}
+			if hook, ok := v.Interface().(afterActionHook); ok {
+				hook.AfterAction(result)
+			}
+
+			// execute the filters in reverse order.
+			for i := executedFilters - 1; i >= 0; i-- {
+				info.Filters[i].AfterAction(controller, result)
+			}
 		}
 
-		// return response to client.
+		beforeResponseMethod := v.MethodByName("BeforeResponse")
+		beforeResponseMethod.Call([]reflect.Value{})
+
+		// return response to client, it will not be invoked again even if it panics.
+		responded = true
 		responseClientMethod := v.MethodByName("ResponseClient")
 		responseClientMethod.Call([]reflect.Value{})
+
+		if hook, ok := v.Interface().(afterResponseHook); ok {
+			hook.AfterResponse()
+		}
+	}
+}
+
+// Recover from the panic raised by the controller.
+// The stack will be written into the request's log and reported to the error reporter,
+// and then the controller's OnPanic hook will be invoked.
+// If ResponseClient has not been invoked, the controller's response will be replaced by the
+// error handler's response unless the hook handled the panic, and then ResponseClient and
+// AfterResponse will be invoked, so that the session is still saved.
+// ResponseClient will only be invoked once, so that the session will not be saved twice, if it panicked
+// before sending the response, the error handler's response will be sent directly.
+func recoverController(v reflect.Value, info *ControllerInfo, w http.ResponseWriter, r *http.Request, err interface{}, responded bool) {
+	stack := debug.Stack()
+	if info.Log != nil {
+		info.Log.Error(fmt.Sprintf("%v\n%s", err, stack))
+	}
+	if App.errorReporter != nil {
+		App.errorReporter.Report(r, err, stack)
+	}
+
+	handled := callOnPanic(v, info, err)
+	responder, ok := v.Interface().(errorResponder)
+
+	if responded {
+		if ok && !responder.isResponseSent() {
+			recorder := newResponseRecorder()
+			App.errorHandler(recorder, r, http.StatusInternalServerError, err, 3)
+			recorder.flush(w)
+		}
+		return
+	}
+
+	if !handled {
+		recorder := newResponseRecorder()
+		App.errorHandler(recorder, r, http.StatusInternalServerError, err, 3)
+
+		if !ok || !responder.setErrorResponse(recorder) {
+			recorder.flush(w)
+			return
+		}
+	}
+
+	defer func() {
+		if err := recover(); err != nil && (info.Log != nil) {
+			info.Log.Error(fmt.Sprintf("Panics while recovering: %v\n%s", err, debug.Stack()))
+		}
+	}()
+	v.MethodByName("ResponseClient").Call([]reflect.Value{})
+	if hook, ok := v.Interface().(afterResponseHook); ok {
+		hook.AfterResponse()
 	}
 }
 
+// Invoke the controller's OnPanic hook, returns true if the hook handled the panic.
+// The panic raised by the hook will be recovered and logged.
+func callOnPanic(v reflect.Value, info *ControllerInfo, err interface{}) (handled bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			handled = false
+			if info.Log != nil {
+				info.Log.Error(fmt.Sprintf("OnPanic panics: %v\n%s", e, debug.Stack()))
+			}
+		}
+	}()
+
+	onPanicMethod := v.MethodByName("OnPanic")
+	if !onPanicMethod.IsValid() {
+		return false
+	}
+	for _, value := range onPanicMethod.Call([]reflect.Value{reflect.ValueOf(&err).Elem()}) {
+		if _value, ok := value.Interface().(bool); ok {
+			handled = _value
+		}
+		break
+	}
+	return handled
+}
+
 // Remove the controller's prefix and suffix that you set.
 // If it is not a controller, false will be return.
 func getControllerName(name string) (string, bool) {
@@ -156,6 +288,26 @@ func SetErrorHandler(handler ErrorHandler) {
 	App.errorHandler = handler
 }
 
+func SetErrorReporter(reporter ErrorReporter) {
+	App.errorReporter = reporter
+}
+
+func SetCache(cache Cache) {
+	App.Cache = cache
+}
+
+func SetViewEngine(engine ViewEngine) {
+	viewEngineMutex.Lock()
+	defer viewEngineMutex.Unlock()
+	App.viewEngine = engine
+}
+
+// Set the file system of the views, such as embed.FS, the views are searched in the view.search_path of it.
+// It must be invoked before registering the controllers.
+func SetViewFS(fsys fs.FS) {
+	App.viewFS = fsys
+}
+
 func SetSessionStore(store session.Store) {
 	App.sessionStore = store
 }
@@ -21,16 +21,38 @@ type Config struct {
 	defaultAction string
 
 	// View Configuration
-	viewLayout    string
-	viewLayoutDir string
-	viewDir       string
-	viewSuffix    string
+	viewLayout     string
+	viewLayoutDir  string
+	viewDir        string
+	viewSuffix     string
+	viewErrorDir   string
+	viewWidgetDir  string
+	viewEngine     string
+	viewPrecompile bool
+	viewSearchPath []string
+
+	// Theme Configuration
+	themeDir string
 
 	// Session Configuration
-	enableSession bool
-	sessionName   string
-	sessionStore  string
-	sessionMaxAge int
+	enableSession     bool
+	sessionName       string
+	sessionStore      string
+	sessionMaxAge     int
+	sessionSecret     string
+	sessionFileDir    string
+	sessionFilePath   string
+	sessionGcInterval int
+	// Session Cookie Configuration
+	sessionCookiePath      string
+	sessionCookieDomain    string
+	sessionCookieSecure    bool
+	sessionCookieHttpOnly  bool
+	sessionCookieSameSite  string
+	sessionIdleTimeout     int
+	sessionAbsoluteTimeout int
+	sessionEnableIndex     bool
+	sessionMaxConcurrent   int
 
 	// Log Configuration
 	enableLog bool
@@ -67,8 +89,22 @@ type Config struct {
 	routerHandleMethodNotAllowed bool
 	routerHandleOPTIONS          bool
 
+	// Cache Configuration
+	enableCache     bool
+	cacheDriver     string
+	cacheMemorySize int
+	cacheFileDir    string
+	cacheFilePath   string
+	cachePrefix     string
+	cacheStaleTtl   int
+	cacheRedisLock  bool
+
+	// Asset Configuration
+	assetCombine   bool
+	assetOutputDir string
+	assetOutputUrl string
+
 	// Redis Configuration
-	enableCache      bool
 	redisNetwork     string
 	redisAddress     string
 	redisPassword    string
@@ -125,6 +161,30 @@ func (this *Config) ViewSuffix() string {
 	return this.viewSuffix
 }
 
+func (this *Config) ViewErrorDir() string {
+	return this.viewErrorDir
+}
+
+func (this *Config) ViewWidgetDir() string {
+	return this.viewWidgetDir
+}
+
+func (this *Config) ViewEngine() string {
+	return this.viewEngine
+}
+
+func (this *Config) ViewSearchPath() []string {
+	return this.viewSearchPath
+}
+
+func (this *Config) ViewPrecompile() bool {
+	return this.viewPrecompile
+}
+
+func (this *Config) ThemeDir() string {
+	return this.themeDir
+}
+
 func (this *Config) EnableSession() bool {
 	return this.enableSession
 }
@@ -133,6 +193,42 @@ func (this *Config) SessionName() string {
 	return this.sessionName
 }
 
+func (this *Config) SessionStore() string {
+	return this.sessionStore
+}
+
+func (this *Config) SessionMaxAge() int {
+	return this.sessionMaxAge
+}
+
+func (this *Config) SessionFilePath() string {
+	return this.sessionFilePath
+}
+
+func (this *Config) SessionCookieSecure() bool {
+	return this.sessionCookieSecure
+}
+
+func (this *Config) SessionCookieSameSite() string {
+	return this.sessionCookieSameSite
+}
+
+func (this *Config) SessionIdleTimeout() int {
+	return this.sessionIdleTimeout
+}
+
+func (this *Config) SessionAbsoluteTimeout() int {
+	return this.sessionAbsoluteTimeout
+}
+
+func (this *Config) SessionEnableIndex() bool {
+	return this.sessionEnableIndex
+}
+
+func (this *Config) SessionMaxConcurrent() int {
+	return this.sessionMaxConcurrent
+}
+
 func (this *Config) EnableLog() bool {
 	return this.enableLog
 }
@@ -177,6 +273,26 @@ func (this *Config) CsrfFormParam() string {
 	return this.csrfFormParam
 }
 
+func (this *Config) EnableCache() bool {
+	return this.enableCache
+}
+
+func (this *Config) CacheDriver() string {
+	return this.cacheDriver
+}
+
+func (this *Config) AssetCombine() bool {
+	return this.assetCombine
+}
+
+func (this *Config) AssetOutputDir() string {
+	return this.assetOutputDir
+}
+
+func (this *Config) AssetOutputUrl() string {
+	return this.assetOutputUrl
+}
+
 func (this *Config) DefaultRoute() string {
 	return this.defaultRoute
 }
@@ -11,7 +11,7 @@ import (
 type Context struct {
 	Request       *http.Request
 	csrfToken     string
-	trueCsrfToken string
+	trueCsrfToken func(generate bool) string // get the true CSRF token from session.
 }
 
 func NewContext(w *http.ResponseWriter, r *http.Request) *Context {
@@ -22,7 +22,13 @@ func NewContext(w *http.ResponseWriter, r *http.Request) *Context {
 }
 
 // Return current request's CSRF token.
+// It is generated on first access, the true CSRF token will be generated and stored in session if it does not exist.
 func (this *Context) CsrfToken() string {
+	if (len(this.csrfToken) == 0) && (this.trueCsrfToken != nil) {
+		if trueToken := this.trueCsrfToken(true); len(trueToken) > 0 {
+			this.csrfToken = GenerateCsrfToken(App.Config.csrfMaskLength, []byte(trueToken))
+		}
+	}
 	return this.csrfToken
 }
 
@@ -32,12 +38,16 @@ func (this *Context) ValidateCsrfToken() bool {
 	if strings.EqualFold("GET", this.Request.Method) || strings.EqualFold("HEAD", this.Request.Method) {
 		return true
 	}
-	if len(this.trueCsrfToken) == 0 {
+	if this.trueCsrfToken == nil {
+		return false
+	}
+	trueToken := this.trueCsrfToken(false)
+	if len(trueToken) == 0 {
 		return false
 	}
 
-	if ValidateCsrfToken(App.Config.csrfMaskLength, this.getCsrfTokenFromForm(), this.trueCsrfToken) ||
-		ValidateCsrfToken(App.Config.csrfMaskLength, this.getCsrfTokenFromHeader(), this.trueCsrfToken) {
+	if ValidateCsrfToken(App.Config.csrfMaskLength, this.getCsrfTokenFromForm(), trueToken) ||
+		ValidateCsrfToken(App.Config.csrfMaskLength, this.getCsrfTokenFromHeader(), trueToken) {
 		return true
 	}
 	return false
@@ -93,3 +103,9 @@ func (this *Context) IsAjax() bool {
 	header := this.Request.Header.Get("X-Requested-With")
 	return strings.Compare("XMLHttpRequest", header) == 0
 }
+
+// Returns a boolean indicating whether the client accepts JSON response.
+func (this *Context) AcceptJson() bool {
+	accept := this.Request.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, "+json")
+}
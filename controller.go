@@ -10,12 +10,24 @@ import (
 	"github.com/HeadwindFly/cheetah/utils/string"
 	log "github.com/go-language/logger"
 	"github.com/go-language/session"
-	"github.com/hoisie/mustache"
 	"net/http"
 	"path"
+	"reflect"
+	"time"
 )
 
 // Controller Interface.
+// The methods will be invoked in the following order for every request:
+//
+//	Init, BeforeAction, action, AfterAction, BeforeResponse, ResponseClient, AfterResponse.
+//
+// AfterAction(result interface{}) and AfterResponse() are optional, they are invoked if the controller has them,
+// WebController implements all of them.
+// The filters declared by the controller's Filters method are executed after BeforeAction, and their AfterAction
+// are executed after the controller's AfterAction in reverse order.
+// If BeforeAction or a filter returns false, the action and AfterAction will be skipped.
+// If the controller panics, the optional method OnPanic(v interface{}) bool will be invoked,
+// and then ResponseClient and AfterResponse will still be invoked unless the response has been sent.
 type ControllerInterface interface {
 	Init(config *ControllerInfo, w *http.ResponseWriter, r *http.Request)
 	BeforeAction() bool
@@ -23,31 +35,61 @@ type ControllerInterface interface {
 	ResponseClient()
 }
 
+// The optional hook which is invoked after the action.
+type afterActionHook interface {
+	AfterAction(result interface{})
+}
+
+// The optional hook which is invoked after responding the client.
+type afterResponseHook interface {
+	AfterResponse()
+}
+
+// It is used to send the response of the error handler by the controller.
+type errorResponder interface {
+	setErrorResponse(recorder *responseRecorder) bool
+	isResponseSent() bool
+}
+
 // Controller Config.
 type ControllerInfo struct {
-	Route          string   // route.
-	PkgPath        string   // package path of the controller.
-	Name           string   // controller's name.
-	FullName       string   // controller's full name.
-	ViewPath       string   // view's path.
-	ActionFullName string   // method' full name.
-	ActionName     string   // action name.
-	Params         []string // params of action,such as {"string","int"} means that the first param type of string,the second param type of int.
-	Layout         string   // layout's name.
-	Log            *log.Log // log.
+	Route          string     // route.
+	PkgPath        string     // package path of the controller.
+	Name           string     // controller's name.
+	FullName       string     // controller's full name.
+	ViewPath       string     // view's path.
+	ActionFullName string     // method' full name.
+	ActionName     string     // action name.
+	Params         []string   // params of action,such as {"string","int"} means that the first param type of string,the second param type of int.
+	Layout         string     // layout's name.
+	ViewEngine     ViewEngine // view engine, nil means that use the application's view engine.
+	Theme          *Theme     // theme of the host, nil means no theme.
+	Filters        []Filter   // filters which apply to the action.
+	Log            *log.Log   // log.
 }
 
 type WebController struct {
-	Name     string           // controller's name.
-	FullName string           // controller's full name.
-	PkgPath  string           // controller's package path.
-	Action   string           // controller's action name
-	ViewPath string           // view's path
-	Layout   string           // layout's name, if empty means that do not use layout.
-	Context  *Context         // Context
-	Response *WebResponse     // web response
-	Session  *session.Session // session
-	Log      *log.Log         // log
+	Name       string           // controller's name.
+	FullName   string           // controller's full name.
+	PkgPath    string           // controller's package path.
+	Action     string           // controller's action name
+	ViewPath   string           // view's path
+	Layout     string           // layout's name, if empty means that do not use layout.
+	ViewEngine ViewEngine       // view engine.
+	Theme      *Theme           // theme, nil means no theme.
+	View       *View            // view, it builds the head and footer blocks of the layout.
+	Context    *Context         // Context
+	Response   *WebResponse     // web response
+	Session    *session.Session // session, it is nil until GetSession is invoked or if the session is disabled.
+	Language   string           // language of the request, the messages of the views are translated into it.
+	Log        *log.Log         // log
+
+	sessionLoaded   bool                        // whether the session has been loaded.
+	sessionModified bool                        // whether the session has been marked as modified.
+	flashesRendered bool                        // whether the flash messages have been rendered by the views.
+	sessionValues   map[interface{}]interface{} // the session's values when it was loaded.
+	fragments       map[string]interface{}      // the cached fragments which are exposed to the view.
+	viewEngine      ViewEngine                  // the view engine without theme.
 }
 
 func (this *WebController) Init(info *ControllerInfo, w *http.ResponseWriter, r *http.Request) {
@@ -58,26 +100,85 @@ func (this *WebController) Init(info *ControllerInfo, w *http.ResponseWriter, r
 	this.Layout = info.Layout
 	this.Log = info.Log
 
+	this.viewEngine = info.ViewEngine
+	if this.viewEngine == nil {
+		this.viewEngine = App.getViewEngine()
+	}
+	this.ViewEngine = this.viewEngine
+	this.Theme = info.Theme
+	if this.Theme != nil {
+		this.ViewEngine = this.Theme.getViewEngine(this.viewEngine)
+	}
+
+	this.View = NewView("", "", "")
+	this.View.theme = this.Theme
+
 	this.Context = NewContext(w, r)
+	this.Context.trueCsrfToken = this.getTrueCsrfToken
+	this.Language = getRequestLanguage(r)
 
 	this.Response = NewWebResponse(w)
 
-	this.getSession(r)
-
 	this.validateCsrfToken()
 }
 
 // Do something before invling the action.
 // If true was returned, the action will be invoked.
 // But on the contrary, it will not invoke the action, just response client directly.
+// The action will not be invoked if the response has been sent, such as the CSRF validation failed.
 func (this *WebController) BeforeAction() bool {
-	return true
+	return !this.Response.IsSent
+}
+
+// Do something after invoking the action.
+// The result is the first return value of the action, nil will be passed if the action returns nothing,
+// it is also passed to the filters' AfterAction. The result can not be replaced, but the response can,
+// such as rendering the result as JSON or responding an error instead, the response is sent by ResponseClient.
+func (this *WebController) AfterAction(result interface{}) {
+
 }
 
+// Do something before responsing client, the response can still be modified.
 func (this *WebController) BeforeResponse() {
 
 }
 
+// Do something after responsing client, such as cleaning up.
+// The response can not be modified anymore.
+func (this *WebController) AfterResponse() {
+
+}
+
+// Invoked if the controller panics, v is the recovered value.
+// If true was returned, it means that the controller's response has been prepared by the hook,
+// otherwise it will be replaced by the error handler's response.
+// In both cases, the response will be sent by ResponseClient.
+func (this *WebController) OnPanic(v interface{}) bool {
+	return false
+}
+
+// Replace the response with the error handler's response.
+// False will be returned if the response is unavailable, the response will not be replaced if it has been sent.
+func (this *WebController) setErrorResponse(recorder *responseRecorder) bool {
+	if this.Response == nil {
+		return false
+	}
+	if this.Response.IsSent {
+		return true
+	}
+	for key, values := range recorder.Header() {
+		this.Response.Writer.Header()[key] = values
+	}
+	this.Response.Status = recorder.status
+	this.Response.Body = recorder.body.String()
+	return true
+}
+
+// Returns a boolean indicating whether the response has been sent.
+func (this *WebController) isResponseSent() bool {
+	return (this.Response != nil) && this.Response.IsSent
+}
+
 // Response client.
 func (this *WebController) ResponseClient() {
 	this.saveSession()
@@ -90,34 +191,129 @@ func (this *WebController) validateCsrfToken() {
 	}
 }
 
-func (this *WebController) getSession(r *http.Request) {
-	if App.Config.enableSession {
-		var err error
-		this.Session, err = App.sessionStore.Get(r, App.Config.sessionName)
+// Get the session, it will be loaded on first access, such as reading the flash messages and the CSRF token,
+// so that the requests which never touch the session cause no store round trips.
+// Nil will be returned if the session is disabled.
+func (this *WebController) GetSession() *session.Session {
+	if !this.sessionLoaded {
+		this.sessionLoaded = true
+		this.loadSession()
+	}
+	return this.Session
+}
+
+// Load the session, it is loaded from the session store only if the request carries the session cookie,
+// otherwise a new session is created without accessing the store.
+// The session will be saved only if it is modified.
+func (this *WebController) loadSession() {
+	if !App.Config.enableSession {
+		return
+	}
+
+	if _, err := this.Context.Request.Cookie(App.Config.sessionName); err == nil {
+		this.Session, err = App.sessionStore.Get(this.Context.Request, App.Config.sessionName)
 		if err != nil {
 			this.Response.InternalServerError(err.Error())
-			return
 		}
+	}
+	if this.Session == nil {
+		this.Session, _ = App.sessionStore.New(this.Context.Request, App.Config.sessionName)
+	}
+
+	now := time.Now().Unix()
+	if !this.Session.IsNew && (isSessionTimeout(this.Session, now) || !this.checkSessionIndex()) {
+		// Discard the timed out or revoked session, and start a new one.
+		this.unindexSession()
+		deleteSession(App.sessionStore, this.Session)
+		this.Session, _ = App.sessionStore.New(this.Context.Request, App.Config.sessionName)
+	}
+	this.sessionValues = copySessionValues(this.Session.Values)
+
+	// The new session's timestamps will be set when it is saved,
+	// the active session will be saved when its last activity time is refreshed.
+	if !this.Session.IsNew {
+		touchSession(this.Session, now)
+	}
+}
+
+// Regenerate the session ID and rotate the true CSRF token, the values will be migrated to the new session,
+// and the old session will be deleted. It should be invoked after the user logged in,
+// it prevents session fixation attacks.
+func (this *WebController) RegenerateSession() error {
+	sess := this.GetSession()
+	if sess == nil {
+		return nil
+	}
+
+	sess, err := RegenerateSession(this.Context.Request, sess)
+	if err != nil {
+		return err
+	}
+	this.Session = sess
+	this.sessionModified = true
 
-		this.Context.trueCsrfToken = this.getTrueCsrfToken()
-		this.Context.csrfToken = GenerateCsrfToken(App.Config.csrfMaskLength, []byte(this.Context.trueCsrfToken))
+	if _, ok := sess.Values[App.Config.csrfSessionParam]; ok {
+		sess.Values[App.Config.csrfSessionParam] = stringutil.GenerateRandomString(32)
+		this.Context.csrfToken = ""
 	}
+	return nil
 }
 
-func (this *WebController) getTrueCsrfToken() string {
-	token, ok := this.Session.Values["_csrf"]
+// Destroy the session, such as logging out.
+// The session's values and the true CSRF token will be cleared, and the session cookie will be deleted.
+func (this *WebController) DestroySession() error {
+	sess := this.GetSession()
+	if sess == nil {
+		return nil
+	}
 
-	if !ok {
+	this.unindexSession()
+	this.sessionModified = true
+	this.Context.csrfToken = ""
+	return DestroySession(sess)
+}
+
+// Mark the session as modified, so that it will be saved.
+// The changes of session's values are detected automatically, but the in-place
+// modifications of reference values(such as map and pointer) and options are not.
+func (this *WebController) MarkSessionModified() {
+	this.sessionModified = true
+}
+
+// Returns a boolean indicating whether the session has been modified.
+func (this *WebController) isSessionModified() bool {
+	if this.Session == nil {
+		return false
+	}
+	return this.sessionModified || !reflect.DeepEqual(this.sessionValues, this.Session.Values)
+}
+
+// Get the true CSRF token from session.
+// If the token does not exist, it will be generated when generate is true, otherwise empty string will be returned.
+func (this *WebController) getTrueCsrfToken(generate bool) string {
+	sess := this.GetSession()
+	if sess == nil {
+		return ""
+	}
+
+	token, ok := sess.Values[App.Config.csrfSessionParam].(string)
+	if !ok && generate {
 		token = stringutil.GenerateRandomString(32)
-		this.Session.Values["_csrf"] = token
+		sess.Values[App.Config.csrfSessionParam] = token
 	}
-	return token.(string)
+	return token
 }
 
+// Save the session only if it has been modified.
 func (this *WebController) saveSession() {
-	if App.Config.enableSession {
+	if App.Config.enableSession && this.isSessionModified() {
+		touchSession(this.Session, time.Now().Unix())
 		if err := this.Session.Save(this.Response.Writer); err != nil {
 			this.Response.InternalServerError(fmt.Sprintf("Error saving session: %v", err))
+			return
+		}
+		if !isBuiltinSessionStore(App.sessionStore) {
+			setSessionCookieSameSite(this.Response.Writer.Header(), App.Config.sessionName)
 		}
 	}
 }
@@ -128,7 +324,14 @@ func (this *WebController) Render(context ...interface{}) {
 
 func (this *WebController) RenderData(data string, context ...interface{}) {
 	this.Response.SetHtmlHeader()
-	this.Response.Body = mustache.Render(data, context...)
+	// The view helpers, the head and footer blocks are exposed by the view scope.
+	context = append(context, this.getViewScope())
+	body, err := this.ViewEngine.Render(data, context...)
+	if err != nil {
+		this.renderError(err)
+		return
+	}
+	this.Response.Body = body
 }
 
 // @param name the view file name
@@ -142,11 +345,25 @@ func (this *WebController) RenderFile(name string, context ...interface{}) {
 	}
 	file := this.getViewFile(name)
 
+	// The flash messages, the cached fragments, the head and footer blocks and the view helpers
+	// are exposed to the view and layout by the view scope.
+	context = append(context, this.getViewScope())
+
+	var body string
+	var err error
 	if len(this.Layout) > 0 {
-		this.Response.Body = mustache.RenderFileInLayout(file, this.getLayoutFile(), context...)
+		body, err = this.ViewEngine.RenderFileInLayout(file, this.getLayoutFile(), context...)
 	} else {
-		this.Response.Body = mustache.RenderFile(file, context...)
+		body, err = this.ViewEngine.RenderFile(file, context...)
+	}
+	if err != nil {
+		this.renderError(err)
+		return
+	}
+	if this.View != nil {
+		body = this.View.replaceBlocks(body)
 	}
+	this.Response.Body = body
 }
 
 func (this *WebController) RenderPartial(context ...interface{}) {
@@ -154,6 +371,31 @@ func (this *WebController) RenderPartial(context ...interface{}) {
 }
 
 func (this *WebController) RenderPartialFile(name string, context ...interface{}) {
+	body, err := this.RenderPartialString(name, context...)
+	if err != nil {
+		this.renderError(err)
+		return
+	}
+	this.Response.Body = body
+}
+
+// Log the error of rendering the view and respond the internal server error.
+// The error is only responded in ModeDev, because it exposes the file paths and the internals of the views.
+func (this *WebController) renderError(err error) {
+	if this.Log != nil {
+		this.Log.Error("Error rendering view: " + err.Error())
+	}
+	if App.mode == ModeDev {
+		this.Response.InternalServerError(err.Error())
+		return
+	}
+	this.Response.Status = http.StatusInternalServerError
+	this.Response.Body = http.StatusText(this.Response.Status)
+	this.Response.Send()
+}
+
+// Render the partial view file and returns the result, it does not modify the response.
+func (this *WebController) RenderPartialString(name string, context ...interface{}) (string, error) {
 	if len(name) == 0 {
 		name = BuildPrettyRoute(this.Action) + App.Config.viewSuffix
 	} else {
@@ -161,7 +403,17 @@ func (this *WebController) RenderPartialFile(name string, context ...interface{}
 	}
 	file := this.getViewFile(name)
 
-	this.Response.Body = mustache.RenderFile(file, context...)
+	return this.ViewEngine.RenderFile(file, append(context, this.getViewScope())...)
+}
+
+// Get the host which serves the request, and the name and port of the request's host.
+// The name is empty if the request is served by the default host.
+func (this *WebController) getRequestHost() (host *Host, name string, port string) {
+	var r *http.Request
+	if this.Context != nil {
+		r = this.Context.Request
+	}
+	return getRequestHost(r)
 }
 
 func (this *WebController) getLayoutFile() string {
@@ -231,6 +483,12 @@ func (this *WebController) getViewFile(name string) string {
 	return path.Join(this.ViewPath, name)
 }
 
+// Get the view engine of the controller.
+// Returns nil default, means that use the application's view engine.
+func (this *WebController) GetViewEngine() ViewEngine {
+	return nil
+}
+
 // Get layout name
 // If it is set as "FALSE" means that disabled the layout.
 // Returns empty string default, means that use the global layout of configuration.
@@ -0,0 +1,349 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The invoked hooks of LifecycleController.
+var lifecycle []string
+
+type LifecycleController struct {
+	WebController
+}
+
+func (this *LifecycleController) Init(info *ControllerInfo, w *http.ResponseWriter, r *http.Request) {
+	lifecycle = append(lifecycle, "Init")
+	this.WebController.Init(info, w, r)
+}
+
+func (this *LifecycleController) BeforeAction() bool {
+	lifecycle = append(lifecycle, "BeforeAction")
+	if this.Context.Request.URL.Query().Get("skip") != "" {
+		this.Response.Forbidden("skipped")
+		return false
+	}
+	return this.WebController.BeforeAction()
+}
+
+func (this *LifecycleController) ActionIndex() string {
+	lifecycle = append(lifecycle, "Action")
+	if this.Context.Request.URL.Query().Get("panic") == "action" {
+		panic("boom")
+	}
+	this.RenderText("index")
+	return "result"
+}
+
+func (this *LifecycleController) AfterAction(result interface{}) {
+	lifecycle = append(lifecycle, fmt.Sprintf("AfterAction(%v)", result))
+	// The hook replaces the response by the result.
+	if this.Context.Request.URL.Query().Get("json") != "" {
+		this.RenderJson(fmt.Sprintf(`{"result":%q}`, result))
+	}
+}
+
+func (this *LifecycleController) BeforeResponse() {
+	lifecycle = append(lifecycle, "BeforeResponse")
+	this.Response.SetHeader("X-Lifecycle", "BeforeResponse")
+}
+
+func (this *LifecycleController) ResponseClient() {
+	lifecycle = append(lifecycle, "ResponseClient")
+	panicAt := this.Context.Request.URL.Query().Get("panic")
+	if panicAt == "before-send" {
+		panic("boom before send")
+	}
+	this.WebController.ResponseClient()
+	if panicAt == "after-send" {
+		panic("boom after send")
+	}
+}
+
+func (this *LifecycleController) AfterResponse() {
+	lifecycle = append(lifecycle, "AfterResponse")
+	if this.Context.Request.URL.Query().Get("panic") == "after" {
+		panic("boom after response")
+	}
+}
+
+func (this *LifecycleController) OnPanic(v interface{}) bool {
+	lifecycle = append(lifecycle, fmt.Sprintf("OnPanic(%v)", v))
+	return false
+}
+
+func serveLifecycle(url string) *httptest.ResponseRecorder {
+	app := App
+	defer func() {
+		App = app
+	}()
+
+	App = NewApplication()
+	App.Config.enableLog = false
+	App.Config.enableSession = false
+	App.Config.enableCsrfValidation = false
+	App.errorHandler = func(w http.ResponseWriter, r *http.Request, status int, v interface{}, callDepth int) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "error: %v", v)
+	}
+
+	info := &ControllerInfo{
+		Route:          "/lifecycle",
+		Name:           "Lifecycle",
+		FullName:       "LifecycleController",
+		ActionFullName: "ActionIndex",
+		ActionName:     "Index",
+	}
+	handle := generateRouteHandle(info.Route, reflect.TypeOf(LifecycleController{}), info)
+
+	lifecycle = nil
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest("GET", url, nil), httprouter.Params{})
+	return w
+}
+
+func assertLifecycle(t *testing.T, expected ...string) {
+	if strings.Join(lifecycle, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("The lifecycle should be %v.\nthe wrong result: %v", expected, lifecycle)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	w := serveLifecycle("/lifecycle")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "AfterAction(result)", "BeforeResponse", "ResponseClient", "AfterResponse")
+
+	if (w.Code != http.StatusOK) || (w.Body.String() != "index") {
+		t.Errorf("The response should be 200 \"index\".\nthe wrong result: %d \"%s\"", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Lifecycle") != "BeforeResponse" {
+		t.Errorf("The response should be modified by BeforeResponse.")
+	}
+}
+
+func TestLifecycleAfterActionResponse(t *testing.T) {
+	w := serveLifecycle("/lifecycle?json=1")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "AfterAction(result)", "BeforeResponse", "ResponseClient", "AfterResponse")
+
+	if body := w.Body.String(); body != `{"result":"result"}` {
+		t.Errorf("The response should be replaced by AfterAction.\nthe wrong result: %s", body)
+	}
+}
+
+// The controller which implements ControllerInterface directly, it has no optional hooks.
+type MinimalController struct {
+	w http.ResponseWriter
+}
+
+var _ ControllerInterface = &MinimalController{}
+
+func (this *MinimalController) Init(info *ControllerInfo, w *http.ResponseWriter, r *http.Request) {
+	this.w = *w
+}
+
+func (this *MinimalController) BeforeAction() bool {
+	return true
+}
+
+func (this *MinimalController) ActionIndex() {
+}
+
+func (this *MinimalController) BeforeResponse() {
+}
+
+func (this *MinimalController) ResponseClient() {
+	this.w.Write([]byte("minimal"))
+}
+
+func TestMinimalController(t *testing.T) {
+	info := &ControllerInfo{
+		Route:          "/minimal",
+		ActionFullName: "ActionIndex",
+		ActionName:     "Index",
+	}
+	handle := generateRouteHandle(info.Route, reflect.TypeOf(MinimalController{}), info)
+
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest("GET", "/minimal", nil), httprouter.Params{})
+	if w.Body.String() != "minimal" {
+		t.Errorf("The controller without the optional hooks should be served.\nthe wrong result: %s", w.Body.String())
+	}
+}
+
+func TestLifecycleBeforeActionShortCircuit(t *testing.T) {
+	w := serveLifecycle("/lifecycle?skip=1")
+
+	assertLifecycle(t, "Init", "BeforeAction", "BeforeResponse", "ResponseClient", "AfterResponse")
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("The response status should be %d.\nthe wrong result: %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestLifecyclePanic(t *testing.T) {
+	w := serveLifecycle("/lifecycle?panic=action")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "OnPanic(boom)", "ResponseClient", "AfterResponse")
+
+	if (w.Code != http.StatusInternalServerError) || (w.Body.String() != "error: boom") {
+		t.Errorf("The response should be 500 \"error: boom\".\nthe wrong result: %d \"%s\"", w.Code, w.Body.String())
+	}
+}
+
+func TestLifecyclePanicInResponseClient(t *testing.T) {
+	w := serveLifecycle("/lifecycle?panic=after-send")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "AfterAction(result)", "BeforeResponse", "ResponseClient", "OnPanic(boom after send)")
+
+	if (w.Code != http.StatusOK) || (w.Body.String() != "index") {
+		t.Errorf("The sent response should not be changed.\nthe wrong result: %d \"%s\"", w.Code, w.Body.String())
+	}
+
+	w = serveLifecycle("/lifecycle?panic=before-send")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "AfterAction(result)", "BeforeResponse", "ResponseClient", "OnPanic(boom before send)")
+
+	if (w.Code != http.StatusInternalServerError) || (w.Body.String() != "error: boom before send") {
+		t.Errorf("The response should be 500 \"error: boom before send\".\nthe wrong result: %d \"%s\"", w.Code, w.Body.String())
+	}
+}
+
+func TestLifecyclePanicAfterResponse(t *testing.T) {
+	w := serveLifecycle("/lifecycle?panic=after")
+
+	assertLifecycle(t, "Init", "BeforeAction", "Action", "AfterAction(result)", "BeforeResponse", "ResponseClient", "AfterResponse", "OnPanic(boom after response)")
+
+	if (w.Code != http.StatusOK) || (w.Body.String() != "index") {
+		t.Errorf("The sent response should not be changed.\nthe wrong result: %d \"%s\"", w.Code, w.Body.String())
+	}
+}
+
+type PanicController struct {
+	WebController
+}
+
+func (this *PanicController) ActionIndex() {
+	panic("boom")
+}
+
+func (this *PanicController) OnPanic(v interface{}) bool {
+	switch this.Context.Request.URL.Query().Get("hook") {
+	case "handle":
+		this.Response.Status = http.StatusServiceUnavailable
+		this.Response.Body = "handled"
+		return true
+	case "panic":
+		panic("boom in hook")
+	}
+	return false
+}
+
+type panicReporter struct {
+	values []interface{}
+}
+
+func (this *panicReporter) Report(r *http.Request, v interface{}, stack []byte) {
+	this.values = append(this.values, v)
+}
+
+func servePanic(url string, reporter ErrorReporter) *httptest.ResponseRecorder {
+	app := App
+	defer func() {
+		App = app
+	}()
+
+	App = NewApplication()
+	App.Config.enableLog = false
+	App.Config.enableSession = false
+	App.Config.enableCsrfValidation = false
+	App.errorReporter = reporter
+	App.errorHandler = func(w http.ResponseWriter, r *http.Request, status int, v interface{}, callDepth int) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "error: %v", v)
+	}
+
+	info := &ControllerInfo{
+		Route:          "/panic",
+		ActionFullName: "ActionIndex",
+		ActionName:     "Index",
+	}
+	handle := generateRouteHandle(info.Route, reflect.TypeOf(PanicController{}), info)
+
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest("GET", url, nil), httprouter.Params{})
+	return w
+}
+
+func TestOnPanic(t *testing.T) {
+	cases := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"/panic", http.StatusInternalServerError, "error: boom"},
+		{"/panic?hook=handle", http.StatusServiceUnavailable, "handled"},
+		{"/panic?hook=panic", http.StatusInternalServerError, "error: boom"},
+	}
+	for _, c := range cases {
+		reporter := &panicReporter{}
+		w := servePanic(c.url, reporter)
+		if (w.Code != c.status) || (w.Body.String() != c.body) {
+			t.Errorf("The response of %s should be %d \"%s\".\nthe wrong result: %d \"%s\"", c.url, c.status, c.body, w.Code, w.Body.String())
+		}
+		if (len(reporter.values) != 1) || (reporter.values[0] != "boom") {
+			t.Errorf("The panic of %s should be reported once.\nthe wrong result: %v", c.url, reporter.values)
+		}
+	}
+}
+
+type RenderErrorController struct {
+	WebController
+}
+
+func (this *RenderErrorController) ActionIndex() {
+	this.RenderData("{{.broken")
+}
+
+func TestRenderError(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+
+	cases := []struct {
+		mode int
+		body string
+	}{
+		{ModePro, "Internal Server Error"},
+		{ModeDev, "Internal Server Error: template: :1: unclosed action"},
+	}
+	for _, c := range cases {
+		App = NewApplication()
+		App.mode = c.mode
+		App.Config.enableLog = false
+		App.Config.enableSession = false
+		App.Config.enableCsrfValidation = false
+
+		info := &ControllerInfo{
+			Route:          "/render",
+			ActionFullName: "ActionIndex",
+			ActionName:     "Index",
+			ViewEngine:     NewHtmlEngine(),
+		}
+		handle := generateRouteHandle(info.Route, reflect.TypeOf(RenderErrorController{}), info)
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest("GET", "/render", nil), httprouter.Params{})
+		if (w.Code != http.StatusInternalServerError) || (w.Body.String() != c.body) {
+			t.Errorf("The response should be 500 \"%s\".\nthe wrong result: %d \"%s\"", c.body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/go-language/session"
+	"github.com/hoisie/mustache"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// The number of source lines around the stack frame's line.
+const debugSourceLines = 5
+
+type debugContextKey struct{}
+
+// Request-scoped information collected for the debug page.
+type debugInfo struct {
+	ControllerInfo *ControllerInfo
+	Controller     reflect.Value
+}
+
+// Attach an empty debug info to every request, so that the route handle can fill it
+// and the error handler can read it even though the panic is recovered by the router.
+// It is only used in development mode.
+type debugHandler struct {
+	handler http.Handler
+}
+
+func newDebugHandler(handler http.Handler) *debugHandler {
+	return &debugHandler{handler: handler}
+}
+
+func (this *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), debugContextKey{}, &debugInfo{})
+	this.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func getDebugInfo(r *http.Request) *debugInfo {
+	if info, ok := r.Context().Value(debugContextKey{}).(*debugInfo); ok {
+		return info
+	}
+	return nil
+}
+
+// Record the matched route and the controller.
+func recordDebugInfo(r *http.Request, info *ControllerInfo, controller reflect.Value) {
+	if debug := getDebugInfo(r); debug != nil {
+		_info := *info
+		debug.ControllerInfo = &_info
+		debug.Controller = controller
+	}
+}
+
+type debugFrame struct {
+	Function string
+	File     string
+	Line     int
+	Source   []debugSourceLine
+	Expanded bool
+}
+
+type debugSourceLine struct {
+	Number  int
+	Code    string
+	Current bool
+}
+
+type debugPair struct {
+	Key   string
+	Value string
+}
+
+// Render the error page with the stack frames, request, session, route and configuration.
+// It is never enabled in production mode.
+func renderDebugError(w http.ResponseWriter, r *http.Request, status int, v interface{}, callDepth int) {
+	if App.mode != ModeDev {
+		renderErrorView(w, r, status, "")
+		return
+	}
+
+	data := map[string]interface{}{
+		"status":  status,
+		"title":   http.StatusText(status),
+		"message": fmt.Sprint(v),
+		"frames":  getDebugFrames(callDepth + 1),
+		"request": getDebugRequest(r),
+		"headers": getDebugHeaders(r),
+		"query":   getDebugValues(r.URL.Query()),
+		"config":  getDebugConfig(),
+	}
+
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	data["form"] = getDebugValues(r.PostForm)
+
+	if debug := getDebugInfo(r); debug != nil {
+		if debug.ControllerInfo != nil {
+			data["route"] = getDebugRoute(debug.ControllerInfo)
+		}
+		if sess := getDebugSession(debug.Controller); sess != nil {
+			data["session"] = getDebugSessionValues(sess)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, mustache.Render(debugTemplate, data))
+}
+
+// Get stack frames, starts from the frame which raised the panic if possible.
+func getDebugFrames(skip int) []debugFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	all := make([]runtime.Frame, 0)
+	start := 0
+	for {
+		frame, more := frames.Next()
+		all = append(all, frame)
+		if frame.Function == "runtime.gopanic" {
+			start = len(all)
+		}
+		if !more {
+			break
+		}
+	}
+
+	debugFrames := make([]debugFrame, 0)
+	for _, frame := range all[start:] {
+		debugFrames = append(debugFrames, debugFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+			Source:   getDebugSource(frame.File, frame.Line),
+			Expanded: len(debugFrames) == 0,
+		})
+	}
+	return debugFrames
+}
+
+// Read the source lines around the line.
+func getDebugSource(file string, line int) []debugSourceLine {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	lines := make([]debugSourceLine, 0)
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		if number < line-debugSourceLines {
+			continue
+		}
+		if number > line+debugSourceLines {
+			break
+		}
+		lines = append(lines, debugSourceLine{
+			Number:  number,
+			Code:    scanner.Text(),
+			Current: number == line,
+		})
+	}
+	return lines
+}
+
+func getDebugRequest(r *http.Request) []debugPair {
+	return []debugPair{
+		{"Method", r.Method},
+		{"URL", r.URL.String()},
+		{"Host", r.Host},
+		{"Protocol", r.Proto},
+		{"Remote Address", r.RemoteAddr},
+	}
+}
+
+// Get the request headers, the credentials and the sensitive headers will be masked.
+func getDebugHeaders(r *http.Request) []debugPair {
+	pairs := getDebugValues(r.Header)
+	for i, pair := range pairs {
+		if containsDebugWord(pair.Key, debugSensitiveHeaderWords) && (len(pair.Value) > 0) {
+			pairs[i].Value = "******"
+		}
+	}
+	return pairs
+}
+
+// Get the values, the sensitive values will be masked like the configuration.
+func getDebugValues(values map[string][]string) []debugPair {
+	pairs := make([]debugPair, 0, len(values))
+	for key, value := range values {
+		pair := debugPair{key, strings.Join(value, ", ")}
+		if isDebugSensitive(key) && (len(pair.Value) > 0) {
+			pair.Value = "******"
+		}
+		pairs = append(pairs, pair)
+	}
+	sortDebugPairs(pairs)
+	return pairs
+}
+
+func getDebugRoute(info *ControllerInfo) []debugPair {
+	return []debugPair{
+		{"Route", info.Route},
+		{"Controller", info.FullName},
+		{"Controller Name", info.Name},
+		{"Package Path", info.PkgPath},
+		{"Action", info.ActionFullName},
+		{"Action Name", info.ActionName},
+		{"Params", strings.Join(info.Params, ", ")},
+		{"View Path", info.ViewPath},
+		{"Layout", info.Layout},
+	}
+}
+
+// Get the session of the controller, nil will be returned if the controller has no session.
+func getDebugSession(controller reflect.Value) *session.Session {
+	if !controller.IsValid() || (controller.Kind() != reflect.Ptr) || controller.IsNil() {
+		return nil
+	}
+	field := controller.Elem().FieldByName("Session")
+	if !field.IsValid() {
+		return nil
+	}
+	if sess, ok := field.Interface().(*session.Session); ok {
+		return sess
+	}
+	return nil
+}
+
+func getDebugSessionValues(sess *session.Session) []debugPair {
+	pairs := make([]debugPair, 0, len(sess.Values))
+	for key, value := range sess.Values {
+		pairs = append(pairs, debugPair{fmt.Sprint(key), fmt.Sprintf("%v", value)})
+	}
+	sortDebugPairs(pairs)
+	return pairs
+}
+
+// The configuration whose name contains these words will be masked, such as the passwords and secrets.
+var debugSensitiveWords = []string{"password", "secret", "key", "token"}
+
+// The request headers whose name contains these words will also be masked, such as the session cookie.
+var debugSensitiveHeaderWords = []string{"cookie", "authorization"}
+
+// Get the loaded configuration, the sensitive values will be masked.
+func getDebugConfig() []debugPair {
+	v := reflect.ValueOf(App.Config).Elem()
+	t := v.Type()
+	pairs := make([]debugPair, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		value := fmt.Sprint(v.Field(i))
+		if isDebugSensitive(name) && (len(value) > 0) {
+			value = "******"
+		}
+		pairs = append(pairs, debugPair{name, value})
+	}
+	return pairs
+}
+
+func isDebugSensitive(name string) bool {
+	return containsDebugWord(name, debugSensitiveWords)
+}
+
+func containsDebugWord(name string, words []string) bool {
+	name = strings.ToLower(name)
+	for _, word := range words {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortDebugPairs(pairs []debugPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key < pairs[j].Key
+	})
+}
+
+const debugTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>{{status}} {{title}}</title>
+    <style>
+        body {
+            margin: 0;
+            font-family: Helvetica, Arial, sans-serif;
+            font-size: 14px;
+            color: #333;
+        }
+
+        header {
+            padding: 20px 30px;
+            background: #d9534f;
+            color: #fff;
+        }
+
+        header h1 {
+            margin: 0 0 10px 0;
+        }
+
+        section {
+            margin: 20px 30px;
+        }
+
+        h2 {
+            border-bottom: 1px solid #ddd;
+            padding-bottom: 5px;
+        }
+
+        details {
+            margin-bottom: 5px;
+            border: 1px solid #eee;
+        }
+
+        summary {
+            padding: 5px 10px;
+            cursor: pointer;
+            background: #f7f7f7;
+        }
+
+        summary .file {
+            color: #999;
+        }
+
+        pre {
+            margin: 0;
+            padding: 5px 0;
+            overflow: auto;
+        }
+
+        pre span {
+            display: block;
+            padding: 0 10px;
+        }
+
+        pre span.current {
+            background: #fcf8e3;
+            font-weight: bold;
+        }
+
+        pre em {
+            display: inline-block;
+            width: 50px;
+            color: #999;
+            font-style: normal;
+        }
+
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+
+        td {
+            padding: 5px 10px;
+            border-bottom: 1px solid #eee;
+            vertical-align: top;
+            word-break: break-all;
+        }
+
+        td.key {
+            width: 25%;
+            font-weight: bold;
+        }
+    </style>
+</head>
+<body>
+<header>
+    <h1>{{status}} {{title}}</h1>
+    <div>{{message}}</div>
+</header>
+<section>
+    <h2>Stack</h2>
+    {{#frames}}
+    <details{{#Expanded}} open{{/Expanded}}>
+        <summary>{{Function}} <span class="file">{{File}}:{{Line}}</span></summary>
+        <pre>{{#Source}}<span{{#Current}} class="current"{{/Current}}><em>{{Number}}</em>{{Code}}</span>{{/Source}}</pre>
+    </details>
+    {{/frames}}
+</section>
+<section>
+    <h2>Route</h2>
+    <table>{{#route}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/route}}</table>
+</section>
+<section>
+    <h2>Request</h2>
+    <table>{{#request}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/request}}</table>
+</section>
+<section>
+    <h2>Headers</h2>
+    <table>{{#headers}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/headers}}</table>
+</section>
+<section>
+    <h2>Query</h2>
+    <table>{{#query}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/query}}</table>
+</section>
+<section>
+    <h2>Form</h2>
+    <table>{{#form}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/form}}</table>
+</section>
+<section>
+    <h2>Session</h2>
+    <table>{{#session}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/session}}</table>
+</section>
+<section>
+    <h2>Configuration</h2>
+    <table>{{#config}}<tr><td class="key">{{Key}}</td><td>{{Value}}</td></tr>{{/config}}</table>
+</section>
+</body>
+</html>
+`
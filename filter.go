@@ -3,4 +3,464 @@
 
 package cheetah
 
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 type MethodFilter map[string][]string
+
+// Filter is executed around the action.
+// The filters are declared by the controller's Filters method, they are shared by all requests,
+// so that the implementations must be safe for concurrent use.
+type Filter interface {
+	// Invoked before the action, the action will not be invoked if false was returned.
+	BeforeAction(controller *WebController) bool
+
+	// Invoked after the action, the result is the first return value of the action.
+	AfterAction(controller *WebController, result interface{})
+}
+
+// Filter with the action scope.
+type ActionFilter struct {
+	Filter Filter
+	Only   []string // the filter will only be applied to these actions, it applies to all actions if empty.
+	Except []string // the filter will not be applied to these actions.
+}
+
+func NewActionFilter(filter Filter) *ActionFilter {
+	return &ActionFilter{
+		Filter: filter,
+		Only:   make([]string, 0),
+		Except: make([]string, 0),
+	}
+}
+
+// Apply the filter to these actions only.
+func (this *ActionFilter) OnlyActions(actions ...string) *ActionFilter {
+	this.Only = append(this.Only, actions...)
+	return this
+}
+
+// Do not apply the filter to these actions.
+func (this *ActionFilter) ExceptActions(actions ...string) *ActionFilter {
+	this.Except = append(this.Except, actions...)
+	return this
+}
+
+// Returns a boolean indicating whether the filter applies to the action.
+// The action is the action's name without prefix and suffix, such as "Index".
+func (this *ActionFilter) Applies(action string) bool {
+	for _, name := range this.Except {
+		if strings.EqualFold(name, action) {
+			return false
+		}
+	}
+	if len(this.Only) == 0 {
+		return true
+	}
+	for _, name := range this.Only {
+		if strings.EqualFold(name, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the filters from the controller's Filters method, it is invoked once when registering the controller,
+// so that the filters are shared by the actions, such as the counters of RateLimit.
+func getControllerFilters(v reflect.Value) []*ActionFilter {
+	filtersMethod := v.MethodByName("Filters")
+	if !filtersMethod.IsValid() {
+		return nil
+	}
+	for _, value := range filtersMethod.Call([]reflect.Value{}) {
+		if actionFilters, ok := value.Interface().([]*ActionFilter); ok {
+			return actionFilters
+		}
+		break
+	}
+	return nil
+}
+
+// Get the filters which apply to the action.
+func getActionFilters(actionFilters []*ActionFilter, action string) []Filter {
+	filters := make([]Filter, 0)
+	for _, actionFilter := range actionFilters {
+		if (actionFilter.Filter != nil) && actionFilter.Applies(action) {
+			filters = append(filters, actionFilter.Filter)
+		}
+	}
+	return filters
+}
+
+// Returns a boolean indicating whether the controller's type embeds WebController, the filters require it.
+func embedsWebController(t reflect.Type) bool {
+	webControllerType := reflect.TypeOf(WebController{})
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == webControllerType {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	field, ok := t.FieldByName("WebController")
+	return ok && field.Anonymous && ((field.Type == webControllerType) || (field.Type == reflect.PtrTo(webControllerType)))
+}
+
+// Get the embedded WebController of the controller, nil will be returned if not found.
+func getWebController(v reflect.Value) *WebController {
+	if controller, ok := v.Interface().(*WebController); ok {
+		return controller
+	}
+	field := v.Elem().FieldByName("WebController")
+	if !field.IsValid() {
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		controller, _ := field.Interface().(*WebController)
+		return controller
+	}
+	if controller, ok := field.Addr().Interface().(*WebController); ok {
+		return controller
+	}
+	return nil
+}
+
+// Access control filter.
+// The Allow function decides whether the request can access the action,
+// the Deny function will be invoked if the access is denied, it responses 403 Forbidden by default.
+type AccessControl struct {
+	Allow func(controller *WebController) bool
+	Deny  func(controller *WebController)
+}
+
+func NewAccessControl(allow func(controller *WebController) bool) *AccessControl {
+	return &AccessControl{
+		Allow: allow,
+		Deny:  nil,
+	}
+}
+
+func (this *AccessControl) BeforeAction(controller *WebController) bool {
+	if this.Allow(controller) {
+		return true
+	}
+	if this.Deny != nil {
+		this.Deny(controller)
+	} else {
+		controller.Response.Forbidden("You are not allowed to perform this action.")
+	}
+	return false
+}
+
+func (this *AccessControl) AfterAction(controller *WebController, result interface{}) {
+}
+
+// Verb filter, it restricts the request methods of the actions.
+type VerbFilter struct {
+	Verbs []string
+}
+
+func NewVerbFilter(verbs ...string) *VerbFilter {
+	return &VerbFilter{
+		Verbs: verbs,
+	}
+}
+
+func (this *VerbFilter) BeforeAction(controller *WebController) bool {
+	method := controller.Context.Request.Method
+	for _, verb := range this.Verbs {
+		if strings.EqualFold(verb, method) {
+			return true
+		}
+	}
+	controller.Response.SetHeader("Allow", strings.ToUpper(strings.Join(this.Verbs, ", ")))
+	controller.Response.MethodNotAllowed("Method " + method + " is not allowed.")
+	return false
+}
+
+func (this *VerbFilter) AfterAction(controller *WebController, result interface{}) {
+}
+
+// Page cache filter, it caches the status, headers and body of the GET and HEAD responses which status is 200.
+// The pages are stored in the cache component, they are keyed by the host, theme, path, query params and the headers
+// listed in VaryByHeaders, and the listed headers are added into the Vary header.
+// The theme which is set by SetTheme in BeforeAction varies the page, but the theme set in the action does not,
+// because the cached page is looked up before invoking the action.
+// The zero value is usable, it caches the pages without TTL.
+// The requests which carry the session cookie bypass the cache, so that the per-user contents(such as flash
+// messages and CSRF tokens) are never cached or served to the others, and the responses which modified
+// the session or set cookies are not cached.
+type PageCache struct {
+	Duration      time.Duration            // the default TTL.
+	Durations     map[string]time.Duration // the TTLs of the actions, the key is the action name.
+	VaryByParams  []string                 // the query params which vary the page, nil means all of them.
+	VaryByHeaders []string                 // the request headers which vary the page.
+	Tags          []string                 // the tags of the pages, the pages can be invalidated by Cache.InvalidateTags.
+	Cache         Cache                    // the cache, nil means that use App.Cache.
+
+	// it is used if both of Cache and App.Cache are nil, it is created on first use.
+	memoryCache     *MemoryCache
+	memoryCacheOnce sync.Once
+}
+
+// The cached page, it is stored in the cache component.
+type cachedPage struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+func init() {
+	gob.Register(cachedPage{})
+}
+
+// The prefix of the keys of the cached pages.
+const pageCachePrefix = "cheetah:page:"
+
+func NewPageCache(duration time.Duration) *PageCache {
+	return &PageCache{
+		Duration:  duration,
+		Durations: make(map[string]time.Duration),
+	}
+}
+
+// Set the TTL of the action.
+func (this *PageCache) SetDuration(action string, duration time.Duration) *PageCache {
+	if this.Durations == nil {
+		this.Durations = make(map[string]time.Duration)
+	}
+	this.Durations[action] = duration
+	return this
+}
+
+func (this *PageCache) getDuration(action string) time.Duration {
+	if duration, ok := this.Durations[action]; ok {
+		return duration
+	}
+	return this.Duration
+}
+
+func (this *PageCache) getCache() Cache {
+	if this.Cache != nil {
+		return this.Cache
+	}
+	if App.Cache != nil {
+		return App.Cache
+	}
+	this.memoryCacheOnce.Do(func() {
+		if this.memoryCache == nil {
+			this.memoryCache = NewMemoryCache(0)
+		}
+	})
+	return this.memoryCache
+}
+
+func (this *PageCache) key(controller *WebController) string {
+	r := controller.Context.Request
+	query := r.URL.Query()
+
+	var buf bytes.Buffer
+	buf.WriteString(r.Host)
+	if controller.Theme != nil {
+		buf.WriteString("\ntheme=" + controller.Theme.Name)
+	}
+	buf.WriteString("\n" + r.URL.Path)
+
+	params := this.VaryByParams
+	if params == nil {
+		for name := range query {
+			params = append(params, name)
+		}
+		sort.Strings(params)
+	}
+	for _, name := range params {
+		for _, value := range query[name] {
+			buf.WriteString("\n" + name + "=" + value)
+		}
+	}
+	for _, name := range this.VaryByHeaders {
+		buf.WriteString("\n" + http.CanonicalHeaderKey(name) + ":" + r.Header.Get(name))
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	return pageCachePrefix + hex.EncodeToString(sum[:])
+}
+
+// Returns a boolean indicating whether the request can be served by the cache.
+func (this *PageCache) isCacheable(controller *WebController) bool {
+	if !controller.Context.IsGet() && (controller.Context.Request.Method != http.MethodHead) {
+		return false
+	}
+	if App.Config.enableSession {
+		if _, err := controller.Context.Request.Cookie(App.Config.sessionName); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *PageCache) setVaryHeader(controller *WebController) {
+	if len(this.VaryByHeaders) == 0 {
+		return
+	}
+	header := controller.Response.Writer.Header()
+	for _, name := range this.VaryByHeaders {
+		name = http.CanonicalHeaderKey(name)
+		exists := false
+		for _, value := range header["Vary"] {
+			for _, field := range strings.Split(value, ",") {
+				if http.CanonicalHeaderKey(strings.TrimSpace(field)) == name {
+					exists = true
+				}
+			}
+		}
+		if !exists {
+			header.Add("Vary", name)
+		}
+	}
+}
+
+func (this *PageCache) BeforeAction(controller *WebController) bool {
+	if !this.isCacheable(controller) {
+		return true
+	}
+
+	value, err := this.getCache().Get(this.key(controller))
+	if err != nil {
+		return true
+	}
+	page, ok := value.(cachedPage)
+	if !ok {
+		return true
+	}
+
+	header := controller.Response.Writer.Header()
+	for key, values := range page.Header {
+		header[key] = values
+	}
+	controller.Response.Status = page.Status
+	controller.Response.Body = page.Body
+	return false
+}
+
+func (this *PageCache) AfterAction(controller *WebController, result interface{}) {
+	this.setVaryHeader(controller)
+
+	if !this.isCacheable(controller) || (controller.Response.Status != http.StatusOK) || controller.Response.IsSent {
+		return
+	}
+	header := controller.Response.Writer.Header()
+	if controller.isSessionModified() || controller.flashesRendered || (len(header["Set-Cookie"]) > 0) {
+		return
+	}
+
+	page := cachedPage{
+		Status: controller.Response.Status,
+		Header: make(http.Header, len(header)),
+		Body:   controller.Response.Body,
+	}
+	for key, values := range header {
+		page.Header[key] = append([]string(nil), values...)
+	}
+
+	ttl := this.getDuration(controller.Action)
+	var tags []CacheDependency
+	if len(this.Tags) > 0 {
+		tags = append(tags, NewTagDependency(this.Tags...))
+	}
+	this.getCache().SetWithDependency(this.key(controller), page, ttl, tags...)
+}
+
+// The expired windows will be removed when the number of windows reaches it.
+const rateLimitSweepSize = 10000
+
+// Rate limit filter, it limits the number of requests of each client IP address
+// in a fixed time window.
+type RateLimit struct {
+	Limit   int
+	Period  time.Duration
+	mutex   sync.Mutex
+	windows map[string]*rateLimitWindow
+}
+
+type rateLimitWindow struct {
+	count int
+	reset time.Time
+}
+
+func NewRateLimit(limit int, period time.Duration) *RateLimit {
+	return &RateLimit{
+		Limit:   limit,
+		Period:  period,
+		windows: make(map[string]*rateLimitWindow),
+	}
+}
+
+func (this *RateLimit) BeforeAction(controller *WebController) bool {
+	ip := controller.Context.Request.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	now := time.Now()
+	this.mutex.Lock()
+	window, ok := this.windows[ip]
+	if !ok || now.After(window.reset) {
+		if len(this.windows) >= rateLimitSweepSize {
+			this.sweep(now)
+		}
+		window = &rateLimitWindow{count: 0, reset: now.Add(this.Period)}
+		this.windows[ip] = window
+	}
+	window.count++
+	count := window.count
+	reset := window.reset
+	this.mutex.Unlock()
+
+	remaining := this.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	controller.Response.SetHeader("X-RateLimit-Limit", strconv.Itoa(this.Limit))
+	controller.Response.SetHeader("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	controller.Response.SetHeader("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	if count > this.Limit {
+		controller.Response.SetHeader("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+		controller.Response.TooManyRequests("Rate limit exceeded.")
+		return false
+	}
+	return true
+}
+
+func (this *RateLimit) AfterAction(controller *WebController, result interface{}) {
+}
+
+// Remove the expired windows.
+func (this *RateLimit) Sweep() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.sweep(time.Now())
+}
+
+func (this *RateLimit) sweep(now time.Time) {
+	for ip, window := range this.windows {
+		if now.After(window.reset) {
+			delete(this.windows, ip)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"fmt"
+	"github.com/go-language/session"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActionFilterApplies(t *testing.T) {
+	cases := []struct {
+		filter   *ActionFilter
+		action   string
+		expected bool
+	}{
+		{NewActionFilter(nil), "Index", true},
+		{NewActionFilter(nil).OnlyActions("Edit", "Delete"), "delete", true},
+		{NewActionFilter(nil).OnlyActions("Edit", "Delete"), "Index", false},
+		{NewActionFilter(nil).ExceptActions("Login"), "Login", false},
+		{NewActionFilter(nil).ExceptActions("Login"), "Index", true},
+		{NewActionFilter(nil).OnlyActions("Login").ExceptActions("Login"), "Login", false},
+	}
+
+	for _, c := range cases {
+		if c.filter.Applies(c.action) != c.expected {
+			t.Errorf("Applies(\"%s\") with only %v and except %v should be %t.", c.action, c.filter.Only, c.filter.Except, c.expected)
+		}
+	}
+}
+
+var pageRenders int
+
+type PageController struct {
+	WebController
+}
+
+func (this *PageController) ActionIndex() {
+	pageRenders++
+	this.Response.SetHeader("X-Page", "index")
+	this.RenderText(strconv.Itoa(pageRenders))
+}
+
+func servePage(filter *PageCache, url string, header http.Header) *httptest.ResponseRecorder {
+	app := App
+	defer func() {
+		App = app
+	}()
+
+	App = NewApplication()
+	App.Config.enableLog = false
+	App.Config.enableCsrfValidation = false
+	App.sessionStore = NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+
+	info := &ControllerInfo{
+		Route:          "/page",
+		ActionFullName: "ActionIndex",
+		ActionName:     "Index",
+		Filters:        []Filter{filter},
+	}
+	handle := generateRouteHandle(info.Route, reflect.TypeOf(PageController{}), info)
+
+	r := httptest.NewRequest("GET", url, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	handle(w, r, httprouter.Params{})
+	return w
+}
+
+func TestPageCache(t *testing.T) {
+	pageRenders = 0
+	filter := NewPageCache(time.Minute)
+	filter.Cache = NewMemoryCache(0)
+	filter.VaryByHeaders = []string{"Accept-Language"}
+	filter.Tags = []string{"pages"}
+
+	en := http.Header{"Accept-Language": {"en"}}
+	cases := []struct {
+		url      string
+		header   http.Header
+		expected string
+	}{
+		{"/page?a=1&b=2", en, "1"},
+		{"/page?b=2&a=1", en, "1"},
+		{"/page?a=2", en, "2"},
+		{"/page?a=1&b=2", http.Header{"Accept-Language": {"zh"}}, "3"},
+		{"/page?a=1&b=2", http.Header{"Accept-Language": {"en"}, "Cookie": {App.Config.sessionName + "=id"}}, "4"},
+		{"/page?a=1&b=2", en, "1"},
+	}
+	for _, c := range cases {
+		w := servePage(filter, c.url, c.header)
+		if w.Body.String() != c.expected {
+			t.Errorf("%s %v: expected %s, got %s", c.url, c.header, c.expected, w.Body.String())
+		}
+		if w.Header().Get("Vary") != "Accept-Language" || w.Header().Get("X-Page") != "index" {
+			t.Errorf("unexpected headers: %v", w.Header())
+		}
+	}
+
+	filter.Cache.InvalidateTags("pages")
+	if w := servePage(filter, "/page?a=1&b=2", en); w.Body.String() != "5" {
+		t.Errorf("expected the page to be invalidated, got %s", w.Body.String())
+	}
+
+	// The page which rendered the flash messages is not cached.
+	var w http.ResponseWriter = httptest.NewRecorder()
+	controller := &WebController{
+		Action:          "Index",
+		Context:         NewContext(&w, httptest.NewRequest("GET", "/page?flash=1", nil)),
+		Response:        NewWebResponse(&w),
+		flashesRendered: true,
+	}
+	controller.Response.Body = "saved"
+	filter.AfterAction(controller, nil)
+	if ok, _ := filter.Cache.Has(filter.key(controller)); ok {
+		t.Errorf("The page which rendered the flash messages should not be cached.")
+	}
+}
+
+// The number of times that FilterController's Filters method was invoked.
+var filterDeclarations int
+
+type FilterController struct {
+	WebController
+}
+
+func (this *FilterController) Filters() []*ActionFilter {
+	filterDeclarations++
+	return []*ActionFilter{
+		NewActionFilter(NewVerbFilter("GET")),
+		NewActionFilter(NewAccessControl(func(controller *WebController) bool {
+			return controller.Context.Request.Header.Get("X-Role") == "admin"
+		})).OnlyActions("Admin"),
+		NewActionFilter(NewRateLimit(1, time.Minute)).OnlyActions("Index", "Limited"),
+		NewActionFilter(NewPageCache(time.Minute)).OnlyActions("Page"),
+	}
+}
+
+// The banned users are rejected before the filters.
+func (this *FilterController) BeforeAction() bool {
+	if this.Context.Request.Header.Get("X-Banned") != "" {
+		this.Response.Forbidden("banned")
+		return false
+	}
+	return this.WebController.BeforeAction()
+}
+
+func (this *FilterController) ActionIndex() {
+	this.RenderText("index")
+}
+
+func (this *FilterController) ActionAdmin() {
+	this.RenderText("admin")
+}
+
+func (this *FilterController) ActionLimited() {
+	this.RenderText("limited")
+}
+
+func (this *FilterController) ActionPage() {
+	pageRenders++
+	this.RenderText(strconv.Itoa(pageRenders))
+}
+
+type NoWebController struct {
+}
+
+func (this *NoWebController) Init(info *ControllerInfo, w *http.ResponseWriter, r *http.Request) {
+}
+
+func (this *NoWebController) BeforeAction() bool {
+	return true
+}
+
+func (this *NoWebController) AfterAction(result interface{}) {
+}
+
+func (this *NoWebController) BeforeResponse() {
+}
+
+func (this *NoWebController) ResponseClient() {
+}
+
+func (this *NoWebController) AfterResponse() {
+}
+
+func (this *NoWebController) Filters() []*ActionFilter {
+	return []*ActionFilter{NewActionFilter(NewVerbFilter("GET"))}
+}
+
+func (this *NoWebController) ActionIndex() {
+}
+
+func TestFilters(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	App.Config.enableLog = false
+	App.Config.enableSession = false
+	App.Config.enableCsrfValidation = false
+
+	filterDeclarations = 0
+	pageRenders = 0
+	host := App.newHost("filter.example.com")
+	host.RegisterWebController("/filter", &FilterController{})
+	if filterDeclarations != 1 {
+		t.Errorf("The filters should be declared once.\nthe wrong result: %d", filterDeclarations)
+	}
+
+	serve := func(method, route string, header http.Header) *httptest.ResponseRecorder {
+		info := host.routes[route].ControllerInfo
+		handle := generateRouteHandle(info.Route, host.routes[route].ControllerType, info)
+		r := httptest.NewRequest(method, route, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		handle(w, r, httprouter.Params{})
+		return w
+	}
+
+	admin := http.Header{"X-Role": {"admin"}}
+	banned := http.Header{"X-Role": {"admin"}, "X-Banned": {"1"}}
+	cases := []struct {
+		method string
+		route  string
+		header http.Header
+		status int
+		body   string
+	}{
+		{"POST", "/filter/admin", admin, http.StatusMethodNotAllowed, "Method Not Allowed: Method POST is not allowed."},
+		{"GET", "/filter/admin", nil, http.StatusForbidden, "Forbidden: You are not allowed to perform this action."},
+		{"GET", "/filter/admin", admin, http.StatusOK, "admin"},
+		// The rate limit is shared by the actions.
+		{"GET", "/filter/index", nil, http.StatusOK, "index"},
+		{"GET", "/filter/limited", nil, http.StatusTooManyRequests, "Too Many Requests: Rate limit exceeded."},
+		{"GET", "/filter/page", nil, http.StatusOK, "1"},
+		{"GET", "/filter/page", nil, http.StatusOK, "1"},
+		// The cached page is not served to the requests which are rejected by BeforeAction.
+		{"GET", "/filter/page", banned, http.StatusForbidden, "Forbidden: banned"},
+	}
+	for _, c := range cases {
+		w := serve(c.method, c.route, c.header)
+		if (w.Code != c.status) || (w.Body.String() != c.body) {
+			t.Errorf("The response of %s %s should be %d \"%s\".\nthe wrong result: %d \"%s\"", c.method, c.route, c.status, c.body, w.Code, w.Body.String())
+		}
+	}
+
+	defer func() {
+		if err := recover(); !strings.Contains(fmt.Sprint(err), "must embed WebController") {
+			t.Errorf("The controller which declares filters without WebController should not be registered.\nthe wrong result: %v", err)
+		}
+	}()
+	host.RegisterWebController("/nowebcontroller", &NoWebController{})
+}
+
+func TestPageCacheZeroValue(t *testing.T) {
+	pageRenders = 0
+	filter := &PageCache{}
+	filter.SetDuration("Index", time.Minute)
+	for i := 0; i < 2; i++ {
+		if w := servePage(filter, "/page?zero=1", nil); w.Body.String() != "1" {
+			t.Errorf("The page should be cached by the zero value.\nthe wrong result: %s", w.Body.String())
+		}
+	}
+}
+
+func TestPageCacheKeyTheme(t *testing.T) {
+	filter := NewPageCache(time.Minute)
+	keys := make(map[string]bool)
+	for _, theme := range []*Theme{nil, {Name: "dark"}, {Name: "light"}} {
+		var w http.ResponseWriter = httptest.NewRecorder()
+		controller := &WebController{Theme: theme, Context: NewContext(&w, httptest.NewRequest("GET", "/page", nil))}
+		keys[filter.key(controller)] = true
+	}
+	if len(keys) != 3 {
+		t.Errorf("The pages of the themes should be keyed separately.\nthe wrong result: %v", keys)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/gob"
+	"sort"
+)
+
+// The categories of flash messages.
+const (
+	FlashSuccess = "success"
+	FlashError   = "error"
+	FlashInfo    = "info"
+	FlashWarning = "warning"
+)
+
+// The session's param which stores the flash messages.
+const flashSessionParam = "_flash"
+
+func init() {
+	gob.Register(map[string][]string{})
+}
+
+// Get the flash messages from session.
+func (this *WebController) getFlashes() map[string][]string {
+	sess := this.GetSession()
+	if sess == nil {
+		return nil
+	}
+	flashes, _ := sess.Values[flashSessionParam].(map[string][]string)
+	return flashes
+}
+
+// Store the flash messages in session, a new map is always stored, so that the modification can be detected.
+func (this *WebController) setFlashes(flashes map[string][]string) {
+	sess := this.GetSession()
+	if sess == nil {
+		return
+	}
+	if len(flashes) == 0 {
+		delete(sess.Values, flashSessionParam)
+	} else {
+		sess.Values[flashSessionParam] = flashes
+	}
+	this.MarkSessionModified()
+}
+
+func copyFlashes(flashes map[string][]string) map[string][]string {
+	_flashes := make(map[string][]string, len(flashes))
+	for key, messages := range flashes {
+		_flashes[key] = append([]string{}, messages...)
+	}
+	return _flashes
+}
+
+// Set a flash message of the category, the existing messages of the category will be replaced.
+// The flash messages are stored in session, and will be cleared after being read,
+// it is usually used in the post/redirect/get flows.
+func (this *WebController) SetFlash(key, message string) {
+	flashes := copyFlashes(this.getFlashes())
+	flashes[key] = []string{message}
+	this.setFlashes(flashes)
+}
+
+// Add a flash message to the category.
+func (this *WebController) AddFlash(key, message string) {
+	flashes := copyFlashes(this.getFlashes())
+	flashes[key] = append(flashes[key], message)
+	this.setFlashes(flashes)
+}
+
+// Returns a boolean indicating whether the category has flash messages, they will not be cleared.
+func (this *WebController) HasFlash(key string) bool {
+	return len(this.getFlashes()[key]) > 0
+}
+
+// Get the flash messages of the category, and clear them.
+func (this *WebController) GetFlashes(key string) []string {
+	flashes := this.getFlashes()
+	messages, ok := flashes[key]
+	if !ok {
+		return nil
+	}
+
+	flashes = copyFlashes(flashes)
+	delete(flashes, key)
+	this.setFlashes(flashes)
+	return messages
+}
+
+// Get all of the flash messages, and clear them.
+func (this *WebController) GetAllFlashes() map[string][]string {
+	flashes := this.getFlashes()
+	if len(flashes) == 0 {
+		return nil
+	}
+
+	this.setFlashes(nil)
+	return flashes
+}
+
+// Get the flash messages of the view, and clear them.
+// It is a list of {"category": category, "message": message}, it is sorted by category,
+// such as {{#flashes}}<div class="alert-{{category}}">{{message}}</div>{{/flashes}}.
+// Nil will be returned if there is no flash message.
+func (this *WebController) getFlashList() []map[string]string {
+	flashes := this.GetAllFlashes()
+	if len(flashes) == 0 {
+		return nil
+	}
+	// The page which renders the flash messages is not cached.
+	this.flashesRendered = true
+
+	categories := make([]string, 0, len(flashes))
+	for category := range flashes {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	list := make([]map[string]string, 0)
+	for _, category := range categories {
+		for _, message := range flashes[category] {
+			list = append(list, map[string]string{"category": category, "message": message})
+		}
+	}
+	return list
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"html/template"
+	"time"
+)
+
+// The prefix of the keys of the cached fragments.
+const fragmentCachePrefix = "cheetah:fragment:"
+
+// Get the HTML of the fragment from the application cache, or render it by fn and cache it with the TTL
+// and dependencies, such as the navigation menus and sidebars which are expensive but change rarely.
+// The HTML is exposed to the view and layout rendered by RenderFile as the variable named name,
+// it should be rendered without escaping, such as {{{sidebar}}}.
+// The fragment is rendered every time if the cache is disabled.
+func (this *WebController) CacheFragment(name string, key string, ttl time.Duration, fn func() string, dependencies ...CacheDependency) string {
+	html, _ := this.cacheFragment(name, key, ttl, func() (string, error) {
+		return fn(), nil
+	}, dependencies)
+	return html
+}
+
+// Cache the fragment rendered from the partial view file, the file is the view file name without suffix.
+// The fragment will not be cached if failed to render it.
+func (this *WebController) CacheFragmentFile(name string, key string, ttl time.Duration, file string, context ...interface{}) (string, error) {
+	return this.cacheFragment(name, key, ttl, func() (string, error) {
+		return this.RenderPartialString(file, context...)
+	}, nil)
+}
+
+func (this *WebController) cacheFragment(name string, key string, ttl time.Duration, fn func() (string, error), dependencies []CacheDependency) (string, error) {
+	html, err := this.getFragment(key, ttl, fn, dependencies)
+	if err != nil {
+		return "", err
+	}
+	if this.fragments == nil {
+		this.fragments = make(map[string]interface{})
+	}
+	// The fragment is HTML, it should not be escaped by the html/template engine.
+	this.fragments[name] = template.HTML(html)
+	return html, nil
+}
+
+// Cache the block of the view, the block is rendered by fn if it is not cached.
+// The ttl is parsed by time.ParseDuration, such as "10m", and the tags are the tag dependencies of the block.
+// It is the view function cache, such as {{cache "sidebar" "10m" "sidebar" . "posts"}} with HtmlEngine,
+// the third argument is the name of the template which renders the block, such as {{define "sidebar"}}...{{end}},
+// and the fourth one is its data, and {{#cache "sidebar" "10m" "posts"}}...{{/cache}} with mustache,
+// the variables of the mustache's block are looked up in the view's context.
+func (this *WebController) CacheBlock(key string, ttl string, fn func() (string, error), tags ...string) (template.HTML, error) {
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return "", err
+	}
+	var dependencies []CacheDependency
+	if len(tags) > 0 {
+		dependencies = append(dependencies, NewTagDependency(tags...))
+	}
+	html, err := this.getFragment(key, duration, fn, dependencies)
+	return template.HTML(html), err
+}
+
+// Get the fragment from the cache, the key is prefixed with the request's host and the theme's name,
+// so that the fragments of the hosts and themes are cached separately.
+func (this *WebController) getFragment(key string, ttl time.Duration, fn func() (string, error), dependencies []CacheDependency) (string, error) {
+	if App.Cache == nil {
+		return fn()
+	}
+
+	_, host, _ := this.getRequestHost()
+	theme := ""
+	if this.Theme != nil {
+		theme = this.Theme.Name
+	}
+	key = fragmentCachePrefix + host + ":" + theme + ":" + key
+	if value, err := App.Cache.Get(key); err == nil {
+		if html, ok := value.(string); ok {
+			return html, nil
+		}
+	}
+
+	html, err := fn()
+	if err != nil {
+		return "", err
+	}
+	if err = App.Cache.SetWithDependency(key, html, ttl, dependencies...); (err != nil) && (this.Log != nil) {
+		this.Log.Error("Error caching fragment " + key + ": " + err.Error())
+	}
+	return html, nil
+}
+
+// Returns the context of the cached fragments, nil will be returned if there is no fragment.
+func (this *WebController) getFragmentContext() map[string]interface{} {
+	if len(this.fragments) == 0 {
+		return nil
+	}
+	return this.fragments
+}
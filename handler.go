@@ -4,12 +4,13 @@
 package cheetah
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hoisie/mustache"
+	"io/fs"
 	"net/http"
-	"runtime"
-	"runtime/debug"
-	"strings"
+	"path"
+	"strconv"
 )
 
 type NotFoundHandler struct {
@@ -32,23 +33,144 @@ func panicHandler(w http.ResponseWriter, r *http.Request, v interface{}) {
 
 type ErrorHandler func(http.ResponseWriter, *http.Request, int, interface{}, int)
 
+// Error reporter, it is used to report the panics of controllers to the external services,
+// such as an error tracker or a mailbox.
+type ErrorReporter interface {
+	// Report the recovered value and the stack of the panic.
+	Report(r *http.Request, v interface{}, stack []byte)
+}
+
+// Problem details for HTTP APIs, see also RFC 7807.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func NewProblemDetails(r *http.Request, status int, detail string) *ProblemDetails {
+	return &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.RequestURI(),
+	}
+}
+
 func defaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, v interface{}, callDepth int) {
-	w.WriteHeader(status)
+	detail := getErrorDetail(status, v)
+
+	context := &Context{Request: r}
+	if context.IsAjax() || context.AcceptJson() {
+		renderErrorJson(w, r, status, detail)
+		return
+	}
+
+	if (App.mode == ModeDev) && (status >= 500) {
+		renderDebugError(w, r, status, v, callDepth+1)
+		return
+	}
 
-	title := http.StatusText(status)
-	body := fmt.Sprintf("<h1>%d %s</h1>", status, http.StatusText(status))
+	renderErrorView(w, r, status, detail)
+}
 
+// Get the detail of the error which is sent to the client.
+// All of the details will be shown in development mode, otherwise only the message strings of the client errors
+// will be shown, the server errors and the other values(such as errors) may contain the internal information.
+func getErrorDetail(status int, v interface{}) string {
 	if App.mode == ModeDev {
-		if _, file, line, ok := runtime.Caller(callDepth); ok {
-			body += fmt.Sprintf(`<hr><div class="info">%s: %d</div><br><div class="info">%s</div>`, file, line, v)
+		return fmt.Sprint(v)
+	}
+	if message, ok := v.(string); ok && (status < 500) {
+		return message
+	}
+	return ""
+}
+
+// Response problem details as JSON.
+func renderErrorJson(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	body, err := json.Marshal(NewProblemDetails(r, status, detail))
+	if err != nil {
+		body = []byte(`{"type":"about:blank","status":` + strconv.Itoa(status) + `}`)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Render the error view of the status.
+// It will look for "{status}.html" first, and then "error.html" under the errors directory,
+// the built-in error page will be rendered if neither of them exists.
+// The views and layout of the host's theme are used if the host has the theme.
+func renderErrorView(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	context := map[string]interface{}{
+		"status":  status,
+		"title":   http.StatusText(status),
+		"message": detail,
+	}
+
+	// Render the built-in error page if the error view does not exist or failed to render.
+	html, ok := renderErrorViewFile(r, status, context)
+	if !ok {
+		body := fmt.Sprintf("<h1>%d %s</h1>", status, http.StatusText(status))
+		html = mustache.Render(errorTemplate, map[string]string{"title": http.StatusText(status), "body": body})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, html)
+}
+
+// Render the error view file by the application's view engine, false will be returned
+// if the view does not exist or failed to render, the rendering error is logged.
+func renderErrorViewFile(r *http.Request, status int, context map[string]interface{}) (string, bool) {
+	engine := App.getViewEngine()
+	fsys := App.getViewFS()
+	if host, _, _ := getRequestHost(r); (host != nil) && (host.theme != nil) {
+		engine = host.theme.getViewEngine(engine)
+		fsys = host.theme.getViewFS(fsys)
+	}
+
+	file, ok := getErrorViewFile(fsys, status)
+	if !ok {
+		return "", false
+	}
+
+	var html string
+	var err error
+	layout := path.Join(App.getViewRoot(), App.Config.viewLayoutDir, App.Config.viewLayout)
+	if isViewFile(fsys, layout) {
+		html, err = engine.RenderFileInLayout(file, layout, context)
+	} else {
+		html, err = engine.RenderFile(file, context)
+	}
+	if err != nil {
+		if App.Config.enableLog && (App.Logger != nil) {
+			log := App.Logger.NewLog()
+			log.Error("Error rendering error view " + file + ": " + err.Error())
+			log.Flush()
+		}
+		return "", false
+	}
+	return html, true
+}
+
+func getErrorViewFile(fsys fs.FS, status int) (string, bool) {
+	dir := path.Join(App.getViewRoot(), App.Config.viewErrorDir)
+	names := []string{strconv.Itoa(status), "error"}
+	for _, name := range names {
+		file := path.Join(dir, name+App.Config.viewSuffix)
+		if isViewFile(fsys, file) {
+			return file, true
 		}
-		body += `<br><hr><h2>STACK INFO:</h2><hr><div class="stack">`
-		stack := string(debug.Stack())
-		stack = strings.Replace(stack, "\n", `<hr>`, -1)
-		stack = strings.Replace(stack, "\t", `&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;&nbsp;`, -1)
-		body += stack + "</div>"
 	}
-	html := mustache.Render(`
+	return "", false
+}
+
+const errorTemplate = `
 	<html>
 <head>
     <title>{{title}}</title>
@@ -77,6 +199,4 @@ func defaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, v i
 {{{body}}}
 </body>
 </html>
-	`, map[string]string{"title": title, "body": body})
-	fmt.Fprint(w, html)
-}
+	`
@@ -0,0 +1,150 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestErrorDetail(t *testing.T) {
+	mode := App.mode
+	defer func() {
+		App.mode = mode
+	}()
+
+	cases := []struct {
+		mode   int
+		status int
+		v      interface{}
+		detail string
+	}{
+		{ModePro, http.StatusNotFound, "The user does not exist.", "The user does not exist."},
+		{ModePro, http.StatusBadRequest, errors.New("sql: no rows in result set"), ""},
+		{ModePro, http.StatusInternalServerError, "boom", ""},
+		{ModeDev, http.StatusInternalServerError, "boom", "boom"},
+	}
+	for _, c := range cases {
+		App.mode = c.mode
+		if detail := getErrorDetail(c.status, c.v); detail != c.detail {
+			t.Errorf("The detail of %d %v should be \"%s\".\nthe wrong result: \"%s\"", c.status, c.v, c.detail, detail)
+		}
+	}
+}
+
+func TestErrorJson(t *testing.T) {
+	r := httptest.NewRequest("GET", "/user/view?id=1", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	defaultErrorHandler(w, r, http.StatusNotFound, "The user does not exist.", 0)
+
+	if w.Header().Get("Content-Type") != "application/problem+json; charset=utf-8" {
+		t.Errorf("The content type should be problem JSON.\nthe wrong result: %s", w.Header().Get("Content-Type"))
+	}
+	problem := &ProblemDetails{}
+	if err := json.Unmarshal(w.Body.Bytes(), problem); err != nil {
+		t.Fatal(err)
+	}
+	expected := ProblemDetails{"about:blank", "Not Found", 404, "The user does not exist.", "/user/view?id=1"}
+	if (w.Code != http.StatusNotFound) || (*problem != expected) {
+		t.Errorf("The problem details should be %v.\nthe wrong result: %d %v", expected, w.Code, *problem)
+	}
+}
+
+func TestErrorView(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	SetViewEngine(NewHtmlEngine())
+	SetViewFS(fstest.MapFS{
+		"views/errors/404.html":   {Data: []byte(`{{.status}} {{.message}}`)},
+		"views/errors/error.html": {Data: []byte(`error {{.status}} {{.title}}`)},
+	})
+
+	cases := []struct {
+		status int
+		v      interface{}
+		body   string
+	}{
+		{http.StatusNotFound, "<missing>", "404 &lt;missing&gt;"},
+		{http.StatusForbidden, "Forbidden", "error 403 Forbidden"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		defaultErrorHandler(w, httptest.NewRequest("GET", "/", nil), c.status, c.v, 0)
+		if (w.Code != c.status) || (w.Body.String() != c.body) {
+			t.Errorf("The error page should be %d \"%s\".\nthe wrong result: %d \"%s\"", c.status, c.body, w.Code, w.Body.String())
+		}
+	}
+
+	// The views and layout of the host's theme are used.
+	RegisterTheme(NewTheme("dark", fstest.MapFS{
+		"views/errors/404.html":     {Data: []byte(`dark {{.status}}`)},
+		"views/layouts/layout.html": {Data: []byte(`<body class="dark">{{template "content" .}}</body>`)},
+	}))
+	host := &Host{}
+	if err := host.SetTheme("dark"); err != nil {
+		t.Fatal(err)
+	}
+	App.hosts = Hosts{"www.example.com": host}
+	for status, body := range map[int]string{http.StatusNotFound: `<body class="dark">dark 404</body>`, http.StatusForbidden: `<body class="dark">error 403 Forbidden</body>`} {
+		w := httptest.NewRecorder()
+		defaultErrorHandler(w, httptest.NewRequest("GET", "http://www.example.com/", nil), status, "", 0)
+		if w.Body.String() != body {
+			t.Errorf("The themed error page should be \"%s\".\nthe wrong result: \"%s\"", body, w.Body.String())
+		}
+	}
+
+	// The built-in error page will be rendered if the error views do not exist.
+	App.hosts = make(Hosts)
+	SetViewFS(fstest.MapFS{})
+	w := httptest.NewRecorder()
+	defaultErrorHandler(w, httptest.NewRequest("GET", "/", nil), http.StatusInternalServerError, "boom", 0)
+	if (w.Code != http.StatusInternalServerError) || (w.Header().Get("Content-Type") != "text/html; charset=utf-8") {
+		t.Errorf("The built-in error page should be rendered.\nthe wrong result: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestDebugConfigMask(t *testing.T) {
+	config := App.Config
+	defer func() {
+		App.Config = config
+	}()
+	_config := *config
+	App.Config = &_config
+	App.Config.sessionSecret = "secret"
+	App.Config.redisPassword = "password"
+	App.Config.serverPort = "8080"
+
+	for _, pair := range getDebugConfig() {
+		switch pair.Key {
+		case "sessionSecret", "redisPassword":
+			if pair.Value != "******" {
+				t.Errorf("The %s should be masked.\nthe wrong result: %s", pair.Key, pair.Value)
+			}
+		case "serverPort":
+			if pair.Value != "8080" {
+				t.Errorf("The %s should not be masked.\nthe wrong result: %s", pair.Key, pair.Value)
+			}
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", App.Config.sessionName+"=id")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("Set-Cookie", "name=value")
+	r.Header.Set("X-Api-Key", "key")
+	r.Header.Set("Accept", "text/html")
+	for _, pair := range getDebugHeaders(r) {
+		if masked := pair.Value == "******"; masked != (pair.Key != "Accept") {
+			t.Errorf("The header %s should be masked: %t.\nthe wrong result: %s", pair.Key, !masked, pair.Value)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package cheetah
 import (
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -13,6 +15,7 @@ import (
 type Host struct {
 	router *Router
 	routes Routes
+	theme  *Theme
 }
 
 func (this *Host) SetNotFoundHandler(handler http.Handler) {
@@ -62,8 +65,11 @@ func (this *Host) RegisterWebController(baseRoute string, controller ControllerI
 	// get package path
 	pkgPath := path.Join(os.Getenv("GOPATH"), "src", v.Elem().Type().PkgPath())
 
-	// set view path
+	// set view path, it is relative to the view file system if it is enabled.
 	viewPath := path.Join(path.Dir(pkgPath), App.Config.viewDir, BuildPrettyRoute(controllerName))
+	if App.isViewFSEnabled() {
+		viewPath = BuildPrettyRoute(controllerName)
+	}
 
 	// get layout
 	viewLayout := ""
@@ -88,6 +94,27 @@ func (this *Host) RegisterWebController(baseRoute string, controller ControllerI
 		}
 	}
 
+	// get view engine, nil means that use the application's view engine.
+	// See also the method named GetViewEngine() of WebController.
+	var viewEngine ViewEngine
+	viewEngineMethod := v.MethodByName("GetViewEngine")
+	if viewEngineMethod.IsValid() {
+		values := viewEngineMethod.Call([]reflect.Value{})
+		for _, value := range values {
+			if _value, ok := value.Interface().(ViewEngine); ok {
+				viewEngine = _value
+			}
+			break
+		}
+	}
+
+	// get the filters, they are declared once and shared by the actions.
+	// See also the method named Filters() of the controller.
+	actionFilters := getControllerFilters(v)
+	if (len(actionFilters) > 0) && !embedsWebController(t) {
+		panic("The " + t.Elem().Name() + " declares filters, it must embed WebController.")
+	}
+
 	for j := 0; j < t.NumMethod(); j++ {
 		_routes := []string{}
 
@@ -136,6 +163,9 @@ func (this *Host) RegisterWebController(baseRoute string, controller ControllerI
 				_routes = append(_routes, routeWithParams) // add route
 			}
 		}
+		// get the filters of the action.
+		filters := getActionFilters(actionFilters, actionName)
+
 		// add route to the routes map.
 		for i := 0; i < len(_routes); i++ {
 			this.routes[_routes[i]] = &RouteInfo{
@@ -151,7 +181,9 @@ func (this *Host) RegisterWebController(baseRoute string, controller ControllerI
 					ActionFullName: method.Name,
 					ActionName:     actionName,
 					Layout:         viewLayout,
+					ViewEngine:     viewEngine,
 					Params:         params,
+					Filters:        filters,
 				},
 			}
 		}
@@ -160,6 +192,7 @@ func (this *Host) RegisterWebController(baseRoute string, controller ControllerI
 
 func (this *Host) generateRouteHandle() {
 	for key, route := range this.routes {
+		route.ControllerInfo.Theme = this.theme
 		handle := generateRouteHandle(route.Route, route.ControllerType, route.ControllerInfo)
 		for i := 0; i < len(route.AllowMethods); i++ {
 			this.router.Handle(route.AllowMethods[i], route.Route, handle)
@@ -177,8 +210,14 @@ func (this *Host) generateRouteHandle() {
 	}
 }
 
+// Register the static resources, the fingerprinted resources of the asset bundles are cached by the client forever.
 func (this *Host) RegisterResources(route, path string) {
-	this.router.ServeFiles("/" + route + "/*filepath", http.Dir(path))
+	this.router.GET("/"+route+"/*filepath", newResourceHandle(http.Dir(path)))
+}
+
+// Register the static resources from the file system, such as embed.FS.
+func (this *Host) RegisterResourcesFS(route string, fsys fs.FS) {
+	this.router.GET("/"+route+"/*filepath", newResourceHandle(http.FS(fsys)))
 }
 
 type Hosts map[string]*Host
@@ -192,3 +231,24 @@ func (this Hosts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		App.defaultHost.router.ServeHTTP(w, r)
 	}
 }
+
+// Get the host which serves the request, and the name and port of the request's host.
+// The name is empty if the request is served by the default host.
+func getRequestHost(r *http.Request) (host *Host, name string, port string) {
+	if r != nil {
+		name = r.Host
+		if h, p, err := net.SplitHostPort(name); err == nil {
+			name, port = h, ":"+p
+		}
+	}
+	if host, ok := App.hosts[name]; ok {
+		return host, name, port
+	}
+	// The only host serves all of the requests.
+	if len(App.hosts) == 1 {
+		for name, host := range App.hosts {
+			return host, name, port
+		}
+	}
+	return App.defaultHost, "", port
+}
@@ -4,6 +4,7 @@
 package cheetah
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 )
@@ -95,8 +96,55 @@ func (this *WebResponse) Forbidden(data string) {
 	this.Send()
 }
 
+func (this *WebResponse) MethodNotAllowed(data string) {
+	this.Status = http.StatusMethodNotAllowed
+	this.Body = http.StatusText(this.Status) + ": " + data
+	this.Send()
+}
+
+func (this *WebResponse) TooManyRequests(data string) {
+	this.Status = http.StatusTooManyRequests
+	this.Body = http.StatusText(this.Status) + ": " + data
+	this.Send()
+}
+
 func (this *WebResponse) Redirect(url string) {
 	this.Status = http.StatusFound
 	this.SetHeader("Location", url)
 	this.Send()
 }
+
+// It records the response of the error handler, so that the controller can send it later.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{
+		header: make(http.Header),
+		status: http.StatusOK,
+	}
+}
+
+func (this *responseRecorder) Header() http.Header {
+	return this.header
+}
+
+func (this *responseRecorder) Write(b []byte) (int, error) {
+	return this.body.Write(b)
+}
+
+func (this *responseRecorder) WriteHeader(status int) {
+	this.status = status
+}
+
+// Write the recorded response to w.
+func (this *responseRecorder) flush(w http.ResponseWriter) {
+	for key, values := range this.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(this.status)
+	w.Write(this.body.Bytes())
+}
@@ -0,0 +1,425 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"github.com/HeadwindFly/cheetah/utils/string"
+	"github.com/go-language/session"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SessionStoreMemory = "MEMORY"
+	SessionStoreCookie = "COOKIE"
+	SessionStoreFile   = "FILE"
+	SessionStoreRedis  = "REDIS"
+)
+
+var errInvalidSessionId = errors.New("Invalid session ID.")
+
+// Create the session store according to the session.store configuration.
+func (this *Application) newSessionStore() session.Store {
+	options := session.Options{
+		Path:     this.Config.sessionCookiePath,
+		Domain:   this.Config.sessionCookieDomain,
+		MaxAge:   this.Config.sessionMaxAge,
+		Secure:   this.Config.sessionCookieSecure,
+		HttpOnly: this.Config.sessionCookieHttpOnly,
+	}
+	gcInterval := time.Duration(this.Config.sessionGcInterval) * time.Second
+
+	switch strings.ToUpper(this.Config.sessionStore) {
+	case SessionStoreMemory:
+		return NewMemorySessionStore(options, gcInterval)
+	case SessionStoreCookie:
+		return NewCookieSessionStore([]byte(this.Config.sessionSecret), options)
+	case SessionStoreFile:
+		return NewFileSessionStore(this.Config.sessionFilePath, options, gcInterval)
+	case SessionStoreRedis:
+		store := session.NewRedisStore(this.getRedisCache().GetPool(), options)
+		store.SetMaxAge(this.Config.sessionMaxAge)
+		return store
+	}
+	panic("The session store is not supported: " + this.Config.sessionStore + ", only support MEMORY, COOKIE, FILE and REDIS.")
+}
+
+// The session store which supports deleting the session by ID,
+// the old session will be deleted when the session is regenerated or destroyed.
+type SessionDeleter interface {
+	Delete(id string) error
+}
+
+// Regenerate the session, it returns a new session which has a new ID and the same values,
+// and the old session will be deleted from the store, it prevents session fixation attacks.
+// The new session must be saved.
+func RegenerateSession(r *http.Request, s *session.Session) (*session.Session, error) {
+	store := App.sessionStore
+	_s, err := store.New(r, s.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	_s.Values = s.Values
+	if s.Options != nil {
+		options := *s.Options
+		_s.Options = &options
+	}
+
+	if err = deleteSession(store, s); err != nil {
+		return nil, err
+	}
+	return _s, nil
+}
+
+// Destroy the session, its values will be cleared and it will be deleted from the store,
+// the session cookie will be deleted when the session is saved.
+func DestroySession(s *session.Session) error {
+	options := session.Options{Path: "/"}
+	if s.Options != nil {
+		options = *s.Options
+	}
+	options.MaxAge = -1
+	s.Options = &options
+	s.Values = make(map[interface{}]interface{})
+
+	return deleteSession(App.sessionStore, s)
+}
+
+// Delete the session from the store. If the store does not implement SessionDeleter,
+// the session will be saved with a negative max age, the stores delete the session in this way.
+func deleteSession(store session.Store, s *session.Session) error {
+	if len(s.ID) == 0 {
+		return nil
+	}
+	if deleter, ok := store.(SessionDeleter); ok {
+		return deleter.Delete(s.ID)
+	}
+
+	_s := session.NewSession(store, s.Name())
+	_s.ID = s.ID
+	options := getSessionOptions(s, session.Options{Path: "/"})
+	options.MaxAge = -1
+	_s.Options = &options
+	return store.Save(&discardResponseWriter{header: make(http.Header)}, _s)
+}
+
+// The response writer which discards the response, such as the cookie of the deleted session.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (this *discardResponseWriter) Header() http.Header {
+	return this.header
+}
+
+func (this *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (this *discardResponseWriter) WriteHeader(int) {
+}
+
+// The session's params which are used to check the idle and absolute timeout.
+const (
+	sessionCreatedParam  = "_created"
+	sessionActivityParam = "_activity"
+)
+
+// Returns a boolean indicating whether the session has timed out according to the idle and absolute timeout.
+func isSessionTimeout(s *session.Session, now int64) bool {
+	if timeout := int64(App.Config.sessionAbsoluteTimeout); timeout > 0 {
+		if created, ok := s.Values[sessionCreatedParam].(int64); ok && (now-created > timeout) {
+			return true
+		}
+	}
+	if timeout := int64(App.Config.sessionIdleTimeout); timeout > 0 {
+		if activity, ok := s.Values[sessionActivityParam].(int64); ok && (now-activity > timeout) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update the session's timestamps.
+// The last activity time will only be updated when it is older than a tenth of the refresh period,
+// so that the session will not be saved on every request, but the store's TTL is extended for the active users.
+func touchSession(s *session.Session, now int64) {
+	if (App.Config.sessionAbsoluteTimeout > 0) || (App.Config.sessionIdleTimeout > 0) {
+		if _, ok := s.Values[sessionCreatedParam]; !ok {
+			s.Values[sessionCreatedParam] = now
+		}
+	}
+	if period := getSessionRefreshPeriod(s); period > 0 {
+		activity, ok := s.Values[sessionActivityParam].(int64)
+		if !ok || (now-activity >= period/10) {
+			s.Values[sessionActivityParam] = now
+		}
+	}
+}
+
+// Get the refresh period of the session, it is the lesser of the idle timeout and the max age.
+// Zero will be returned if neither of them is set.
+func getSessionRefreshPeriod(s *session.Session) int64 {
+	period := int64(App.Config.sessionIdleTimeout)
+	maxAge := int64(App.Config.sessionMaxAge)
+	if s.Options != nil {
+		maxAge = int64(s.Options.MaxAge)
+	}
+	if (maxAge > 0) && ((period <= 0) || (maxAge < period)) {
+		period = maxAge
+	}
+	return period
+}
+
+// Get the SameSite attribute of the session cookie according to the session.cookie_same_site configuration.
+func getSessionCookieSameSite() http.SameSite {
+	switch strings.ToUpper(App.Config.sessionCookieSameSite) {
+	case "LAX":
+		return http.SameSiteLaxMode
+	case "STRICT":
+		return http.SameSiteStrictMode
+	case "NONE":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// Returns a boolean indicating whether the session store is built in,
+// the built-in stores set the SameSite attribute of the session cookie by themselves.
+func isBuiltinSessionStore(store session.Store) bool {
+	switch store.(type) {
+	case *MemorySessionStore, *CookieSessionStore, *FileSessionStore:
+		return true
+	}
+	return false
+}
+
+// Add the SameSite attribute to the session cookie which was set by the external session store,
+// such as the redis store, because the session options have no SameSite attribute.
+func setSessionCookieSameSite(header http.Header, name string) {
+	sameSite := App.Config.sessionCookieSameSite
+	if len(sameSite) == 0 {
+		return
+	}
+	sameSite = strings.ToUpper(sameSite[:1]) + strings.ToLower(sameSite[1:])
+
+	cookies := header["Set-Cookie"]
+	for i, cookie := range cookies {
+		if strings.HasPrefix(cookie, name+"=") && !strings.Contains(strings.ToLower(cookie), "samesite=") {
+			cookies[i] = cookie + "; SameSite=" + sameSite
+		}
+	}
+}
+
+// Generate a random session ID.
+func generateSessionId() string {
+	return base64.RawURLEncoding.EncodeToString(stringutil.GenerateRandomByte(32))
+}
+
+// The session ID must be a base64 URL encoding string without padding,
+// it prevents the file store from path traversal.
+func isValidSessionId(id string) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the session ID from request's cookie, empty string will be returned if it is invalid.
+func getSessionId(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
+	if (err != nil) || !isValidSessionId(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+// Get the session's options, the store's options will be returned if the session has no options.
+func getSessionOptions(s *session.Session, options session.Options) session.Options {
+	if s.Options != nil {
+		return *s.Options
+	}
+	return options
+}
+
+// Set the session cookie, the cookie will be deleted if options.MaxAge is less than 0.
+func setSessionCookie(w http.ResponseWriter, name, value string, options session.Options) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+		SameSite: getSessionCookieSameSite(),
+	}
+	if options.MaxAge > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(options.MaxAge) * time.Second)
+	} else if options.MaxAge < 0 {
+		cookie.Value = ""
+		cookie.Expires = time.Unix(1, 0)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// Encode the session's values by gob.
+// The custom types must be registered by gob.Register before being stored in session.
+func encodeSessionValues(values map[interface{}]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSessionValues(data []byte) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Get the expiration time of the session, zero time means that the session never expires in store.
+func getSessionExpire(options session.Options) time.Time {
+	if options.MaxAge > 0 {
+		return time.Now().Add(time.Duration(options.MaxAge) * time.Second)
+	}
+	return time.Time{}
+}
+
+func isSessionExpired(expire time.Time) bool {
+	return !expire.IsZero() && time.Now().After(expire)
+}
+
+func copySessionValues(values map[interface{}]interface{}) map[interface{}]interface{} {
+	_values := make(map[interface{}]interface{}, len(values))
+	for key, value := range values {
+		_values[key] = value
+	}
+	return _values
+}
+
+// In-memory session store, it is suitable for development and tests.
+// The sessions will be lost if the application restarts,
+// and they can not be shared by multiple processes.
+type MemorySessionStore struct {
+	Options  session.Options
+	mutex    sync.RWMutex
+	sessions map[string]*memorySession
+}
+
+type memorySession struct {
+	values map[interface{}]interface{}
+	expire time.Time
+}
+
+// Create an in-memory session store,
+// the expired sessions will be swept every gcInterval if gcInterval is greater than 0.
+func NewMemorySessionStore(options session.Options, gcInterval time.Duration) *MemorySessionStore {
+	store := &MemorySessionStore{
+		Options:  options,
+		sessions: make(map[string]*memorySession),
+	}
+	if gcInterval > 0 {
+		go store.gc(gcInterval)
+	}
+	return store
+}
+
+func (this *MemorySessionStore) Get(r *http.Request, name string) (*session.Session, error) {
+	s, _ := this.New(r, name)
+
+	id := getSessionId(r, name)
+	if len(id) == 0 {
+		return s, nil
+	}
+
+	this.mutex.RLock()
+	stored, ok := this.sessions[id]
+	this.mutex.RUnlock()
+	if !ok || isSessionExpired(stored.expire) {
+		return s, nil
+	}
+
+	s.ID = id
+	s.Values = copySessionValues(stored.values)
+	s.IsNew = false
+	return s, nil
+}
+
+func (this *MemorySessionStore) New(r *http.Request, name string) (*session.Session, error) {
+	s := session.NewSession(this, name)
+	options := this.Options
+	s.Options = &options
+	s.IsNew = true
+	return s, nil
+}
+
+func (this *MemorySessionStore) Save(w http.ResponseWriter, s *session.Session) error {
+	options := getSessionOptions(s, this.Options)
+
+	if options.MaxAge < 0 {
+		if err := this.Delete(s.ID); err != nil {
+			return err
+		}
+		setSessionCookie(w, s.Name(), "", options)
+		return nil
+	}
+
+	if len(s.ID) == 0 {
+		s.ID = generateSessionId()
+	}
+
+	this.mutex.Lock()
+	this.sessions[s.ID] = &memorySession{
+		values: copySessionValues(s.Values),
+		expire: getSessionExpire(options),
+	}
+	this.mutex.Unlock()
+
+	setSessionCookie(w, s.Name(), s.ID, options)
+	return nil
+}
+
+// Delete the session by ID.
+func (this *MemorySessionStore) Delete(id string) error {
+	this.mutex.Lock()
+	delete(this.sessions, id)
+	this.mutex.Unlock()
+	return nil
+}
+
+// Remove the expired sessions.
+func (this *MemorySessionStore) Sweep() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for id, stored := range this.sessions {
+		if isSessionExpired(stored.expire) {
+			delete(this.sessions, id)
+		}
+	}
+}
+
+func (this *MemorySessionStore) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		this.Sweep()
+	}
+}
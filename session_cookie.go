@@ -0,0 +1,149 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/HeadwindFly/cheetah/utils/string"
+	"github.com/go-language/session"
+	"net/http"
+	"time"
+)
+
+// The maximum length of cookie's value, most of browsers limit the cookie to 4096 bytes.
+const cookieSessionMaxLength = 4000
+
+var (
+	errCookieSessionTooLong = errors.New("The session is too long to be stored in cookie.")
+	errCookieSessionInvalid = errors.New("The session cookie is invalid.")
+)
+
+// Cookie session store, the session's values are stored in the client's cookie,
+// it is suitable for stateless deployments.
+// The cookie is encrypted and authenticated by AES-GCM, the key is derived from the secret.
+type CookieSessionStore struct {
+	Options session.Options
+	aead    cipher.AEAD
+}
+
+func NewCookieSessionStore(secret []byte, options session.Options) *CookieSessionStore {
+	if len(secret) == 0 {
+		panic("The secret of cookie session store can not be empty.")
+	}
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &CookieSessionStore{
+		Options: options,
+		aead:    aead,
+	}
+}
+
+func (this *CookieSessionStore) Get(r *http.Request, name string) (*session.Session, error) {
+	s, _ := this.New(r, name)
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return s, nil
+	}
+
+	// The invalid cookie will be ignored, a new session will be returned.
+	values, err := this.decode(name, cookie.Value)
+	if err != nil {
+		return s, nil
+	}
+
+	s.Values = values
+	s.IsNew = false
+	return s, nil
+}
+
+func (this *CookieSessionStore) New(r *http.Request, name string) (*session.Session, error) {
+	s := session.NewSession(this, name)
+	options := this.Options
+	s.Options = &options
+	s.IsNew = true
+	return s, nil
+}
+
+func (this *CookieSessionStore) Save(w http.ResponseWriter, s *session.Session) error {
+	options := getSessionOptions(s, this.Options)
+
+	if options.MaxAge < 0 {
+		setSessionCookie(w, s.Name(), "", options)
+		return nil
+	}
+
+	value, err := this.encode(s.Name(), s.Values, getSessionExpire(options))
+	if err != nil {
+		return err
+	}
+	if len(value) > cookieSessionMaxLength {
+		return errCookieSessionTooLong
+	}
+
+	setSessionCookie(w, s.Name(), value, options)
+	return nil
+}
+
+// The plain text consists of the expiration time(unix timestamp, 8 bytes, 0 means never)
+// and the gob encoding values, the cookie's name is used as the additional data.
+func (this *CookieSessionStore) encode(name string, values map[interface{}]interface{}, expire time.Time) (string, error) {
+	data, err := encodeSessionValues(values)
+	if err != nil {
+		return "", err
+	}
+
+	plain := make([]byte, 8, 8+len(data))
+	if !expire.IsZero() {
+		binary.BigEndian.PutUint64(plain, uint64(expire.Unix()))
+	}
+	plain = append(plain, data...)
+
+	nonce := stringutil.GenerateRandomByte(this.aead.NonceSize())
+	if nonce == nil {
+		return "", errors.New("Unable to generate nonce.")
+	}
+	sealed := this.aead.Seal(nonce, nonce, plain, []byte(name))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (this *CookieSessionStore) decode(name, value string) (map[interface{}]interface{}, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := this.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errCookieSessionInvalid
+	}
+
+	plain, err := this.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+	if (err != nil) || (len(plain) < 8) {
+		return nil, errCookieSessionInvalid
+	}
+
+	if timestamp := binary.BigEndian.Uint64(plain[:8]); timestamp > 0 {
+		if time.Now().After(time.Unix(int64(timestamp), 0)) {
+			return nil, errCookieSessionInvalid
+		}
+	}
+
+	return decodeSessionValues(plain[8:])
+}
@@ -0,0 +1,217 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/binary"
+	"github.com/go-language/session"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The prefix of session files.
+const fileSessionPrefix = "sess_"
+
+// File session store, every session is stored in a file named "sess_{ID}" under the directory.
+type FileSessionStore struct {
+	Options session.Options
+	dir     string
+}
+
+// Create a file session store, the directory will be created if it does not exist,
+// the expired sessions will be swept every gcInterval if gcInterval is greater than 0.
+func NewFileSessionStore(dir string, options session.Options, gcInterval time.Duration) *FileSessionStore {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		panic(err)
+	}
+
+	store := &FileSessionStore{
+		Options: options,
+		dir:     dir,
+	}
+	if gcInterval > 0 {
+		go store.gc(gcInterval)
+	}
+	return store
+}
+
+func (this *FileSessionStore) Get(r *http.Request, name string) (*session.Session, error) {
+	s, _ := this.New(r, name)
+
+	id := getSessionId(r, name)
+	if len(id) == 0 {
+		return s, nil
+	}
+
+	values, err := this.load(id)
+	if err != nil {
+		if os.IsNotExist(err) || (err == errInvalidSessionId) {
+			return s, nil
+		}
+		return s, err
+	}
+	if values == nil {
+		return s, nil
+	}
+
+	s.ID = id
+	s.Values = values
+	s.IsNew = false
+	return s, nil
+}
+
+func (this *FileSessionStore) New(r *http.Request, name string) (*session.Session, error) {
+	s := session.NewSession(this, name)
+	options := this.Options
+	s.Options = &options
+	s.IsNew = true
+	return s, nil
+}
+
+func (this *FileSessionStore) Save(w http.ResponseWriter, s *session.Session) error {
+	options := getSessionOptions(s, this.Options)
+
+	if options.MaxAge < 0 {
+		if err := this.Delete(s.ID); err != nil {
+			return err
+		}
+		setSessionCookie(w, s.Name(), "", options)
+		return nil
+	}
+
+	if len(s.ID) == 0 {
+		s.ID = generateSessionId()
+	}
+
+	if err := this.save(s.ID, s.Values, getSessionExpire(options)); err != nil {
+		return err
+	}
+
+	setSessionCookie(w, s.Name(), s.ID, options)
+	return nil
+}
+
+// Delete the session file by ID.
+func (this *FileSessionStore) Delete(id string) error {
+	if !isValidSessionId(id) {
+		return nil
+	}
+	err := os.Remove(this.filename(id))
+	if (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Remove the expired session files.
+func (this *FileSessionStore) Sweep() {
+	files, err := ioutil.ReadDir(this.dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), fileSessionPrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(file.Name(), fileSessionPrefix)
+		if expire, err := this.readExpire(id); (err == nil) && isSessionExpired(expire) {
+			this.Delete(id)
+		}
+	}
+}
+
+func (this *FileSessionStore) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		this.Sweep()
+	}
+}
+
+func (this *FileSessionStore) filename(id string) string {
+	return path.Join(this.dir, fileSessionPrefix+id)
+}
+
+// The file consists of the expiration time(unix timestamp, 8 bytes, 0 means never)
+// and the gob encoding values.
+func (this *FileSessionStore) save(id string, values map[interface{}]interface{}, expire time.Time) error {
+	if !isValidSessionId(id) {
+		return errInvalidSessionId
+	}
+
+	data, err := encodeSessionValues(values)
+	if err != nil {
+		return err
+	}
+
+	content := make([]byte, 8, 8+len(data))
+	if !expire.IsZero() {
+		binary.BigEndian.PutUint64(content, uint64(expire.Unix()))
+	}
+	content = append(content, data...)
+
+	// Write into a temporary file first, and then rename it, so that the file is always complete.
+	file, err := ioutil.TempFile(this.dir, "tmp_")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), this.filename(id))
+}
+
+// Load the session's values, nil will be returned if the session has expired.
+func (this *FileSessionStore) load(id string) (map[interface{}]interface{}, error) {
+	if !isValidSessionId(id) {
+		return nil, errInvalidSessionId
+	}
+
+	content, err := ioutil.ReadFile(this.filename(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 8 {
+		return nil, nil
+	}
+
+	if isSessionExpired(parseFileSessionExpire(content[:8])) {
+		return nil, nil
+	}
+
+	return decodeSessionValues(content[8:])
+}
+
+func (this *FileSessionStore) readExpire(id string) (time.Time, error) {
+	file, err := os.Open(this.filename(id))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return time.Time{}, err
+	}
+	return parseFileSessionExpire(header), nil
+}
+
+func parseFileSessionExpire(header []byte) time.Time {
+	timestamp := binary.BigEndian.Uint64(header)
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(timestamp), 0)
+}
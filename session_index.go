@@ -0,0 +1,464 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The session's params which are used to index the session by user.
+const (
+	sessionUserParam  = "_uid"
+	sessionIndexParam = "_sid"
+)
+
+// The last seen time of the indexed session will only be updated at this interval(seconds).
+const sessionIndexTouchInterval = 60
+
+var errSessionIndexDisabled = errors.New("The session index is disabled, please set session.enable_index = on.")
+
+// The information of the session which belongs to an authenticated user.
+type SessionInfo struct {
+	Id        string    `json:"id"`
+	UserId    string    `json:"user_id"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Ip        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// Session index, it indexes the sessions by user ID.
+// The indexed session is only valid while it exists in the index, so that it can be revoked by
+// deleting it from the index, whatever the session store is.
+type SessionIndex interface {
+	// Save the session's information.
+	Save(info *SessionInfo) error
+
+	// Get the session's information, nil will be returned if it does not exist.
+	Get(userId, id string) (*SessionInfo, error)
+
+	// List the sessions of the user.
+	List(userId string) ([]*SessionInfo, error)
+
+	// Delete the sessions of the user.
+	Delete(userId string, ids ...string) error
+}
+
+func SetSessionIndex(index SessionIndex) {
+	App.sessionIndex = index
+}
+
+// Create the session index according to the session store,
+// the REDIS store uses the redis index, and the other stores use the in-memory index.
+func (this *Application) newSessionIndex() SessionIndex {
+	maxAge := time.Duration(this.Config.sessionMaxAge) * time.Second
+	if strings.EqualFold(SessionStoreRedis, this.Config.sessionStore) {
+		pool := this.getRedisCache().GetPool()
+		return NewRedisSessionIndex(func() redisConn { return pool.Get() }, maxAge)
+	}
+	return NewMemorySessionIndex(maxAge)
+}
+
+// List the sessions of the user, such as showing the user's devices.
+func ListUserSessions(userId string) ([]*SessionInfo, error) {
+	if App.sessionIndex == nil {
+		return nil, errSessionIndexDisabled
+	}
+	sessions, err := App.sessionIndex.List(userId)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// Revoke the sessions of the user, the revoked sessions will be discarded when they are accessed.
+func RevokeUserSessions(userId string, ids ...string) error {
+	if App.sessionIndex == nil {
+		return errSessionIndexDisabled
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return App.sessionIndex.Delete(userId, ids...)
+}
+
+// Revoke all of the sessions of the user except the specified sessions.
+func RevokeAllUserSessions(userId string, except ...string) error {
+	sessions, err := ListUserSessions(userId)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(sessions))
+	for _, info := range sessions {
+		if !containsString(except, info.Id) {
+			ids = append(ids, info.Id)
+		}
+	}
+	return RevokeUserSessions(userId, ids...)
+}
+
+// Revoke the least recently used sessions of the user if the number of sessions exceeds max.
+// The specified session will be kept.
+func limitUserSessions(userId string, max int, keep string) error {
+	if max <= 0 {
+		return nil
+	}
+	sessions, err := ListUserSessions(userId)
+	if err != nil {
+		return err
+	}
+	if len(sessions) <= max {
+		return nil
+	}
+
+	ids := make([]string, 0)
+	kept := 0
+	for _, info := range sessions {
+		if (info.Id == keep) || (kept < max-1) {
+			if info.Id != keep {
+				kept++
+			}
+			continue
+		}
+		ids = append(ids, info.Id)
+	}
+	return RevokeUserSessions(userId, ids...)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func getClientIp(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Returns a boolean indicating whether the session info is stale, the session has not been seen for maxAge.
+func isSessionInfoStale(info *SessionInfo, maxAge time.Duration) bool {
+	return (maxAge > 0) && time.Now().After(info.LastSeen.Add(maxAge))
+}
+
+// In-memory session index, the sessions are not shared by multiple processes.
+type MemorySessionIndex struct {
+	maxAge   time.Duration
+	mutex    sync.RWMutex
+	sessions map[string]map[string]*SessionInfo
+}
+
+// Create an in-memory session index, the sessions which have not been seen for maxAge will be removed.
+func NewMemorySessionIndex(maxAge time.Duration) *MemorySessionIndex {
+	return &MemorySessionIndex{
+		maxAge:   maxAge,
+		sessions: make(map[string]map[string]*SessionInfo),
+	}
+}
+
+func (this *MemorySessionIndex) Save(info *SessionInfo) error {
+	_info := *info
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	sessions, ok := this.sessions[info.UserId]
+	if !ok {
+		sessions = make(map[string]*SessionInfo)
+		this.sessions[info.UserId] = sessions
+	}
+	sessions[info.Id] = &_info
+	return nil
+}
+
+func (this *MemorySessionIndex) Get(userId, id string) (*SessionInfo, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	info, ok := this.sessions[userId][id]
+	if !ok || isSessionInfoStale(info, this.maxAge) {
+		return nil, nil
+	}
+	_info := *info
+	return &_info, nil
+}
+
+func (this *MemorySessionIndex) List(userId string) ([]*SessionInfo, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	list := make([]*SessionInfo, 0)
+	for id, info := range this.sessions[userId] {
+		if isSessionInfoStale(info, this.maxAge) {
+			delete(this.sessions[userId], id)
+			continue
+		}
+		_info := *info
+		list = append(list, &_info)
+	}
+	if len(this.sessions[userId]) == 0 {
+		delete(this.sessions, userId)
+	}
+	return list, nil
+}
+
+func (this *MemorySessionIndex) Delete(userId string, ids ...string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, id := range ids {
+		delete(this.sessions[userId], id)
+	}
+	if len(this.sessions[userId]) == 0 {
+		delete(this.sessions, userId)
+	}
+	return nil
+}
+
+// The redis connection, it is satisfied by the connections of the redis cache's pool.
+type redisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Close() error
+}
+
+// The prefix of the redis session index's keys.
+const redisSessionIndexPrefix = "cheetah:session_index:"
+
+// Redis session index, the sessions of every user are stored in a hash,
+// the field is the session's ID and the value is the JSON encoding information.
+type RedisSessionIndex struct {
+	getConn func() redisConn
+	maxAge  time.Duration
+}
+
+// Create a redis session index, the sessions which have not been seen for maxAge will be removed.
+func NewRedisSessionIndex(getConn func() redisConn, maxAge time.Duration) *RedisSessionIndex {
+	return &RedisSessionIndex{
+		getConn: getConn,
+		maxAge:  maxAge,
+	}
+}
+
+func (this *RedisSessionIndex) key(userId string) string {
+	return redisSessionIndexPrefix + userId
+}
+
+func (this *RedisSessionIndex) Save(info *SessionInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	conn := this.getConn()
+	defer conn.Close()
+	if _, err = conn.Do("HSET", this.key(info.UserId), info.Id, data); err != nil {
+		return err
+	}
+	if this.maxAge > 0 {
+		_, err = conn.Do("EXPIRE", this.key(info.UserId), int64(this.maxAge/time.Second))
+	}
+	return err
+}
+
+func (this *RedisSessionIndex) Get(userId, id string) (*SessionInfo, error) {
+	conn := this.getConn()
+	defer conn.Close()
+	reply, err := conn.Do("HGET", this.key(userId), id)
+	if (err != nil) || (reply == nil) {
+		return nil, err
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, errors.New("Unexpected reply of HGET.")
+	}
+	info := &SessionInfo{}
+	if err = json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	if isSessionInfoStale(info, this.maxAge) {
+		return nil, nil
+	}
+	return info, nil
+}
+
+func (this *RedisSessionIndex) List(userId string) ([]*SessionInfo, error) {
+	conn := this.getConn()
+	defer conn.Close()
+	reply, err := conn.Do("HGETALL", this.key(userId))
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New("Unexpected reply of HGETALL.")
+	}
+	list := make([]*SessionInfo, 0, len(values)/2)
+	stale := make([]interface{}, 0)
+	for i := 1; i < len(values); i += 2 {
+		data, ok := values[i].([]byte)
+		if !ok {
+			continue
+		}
+		info := &SessionInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			continue
+		}
+		if isSessionInfoStale(info, this.maxAge) {
+			stale = append(stale, info.Id)
+			continue
+		}
+		list = append(list, info)
+	}
+
+	if len(stale) > 0 {
+		conn.Do("HDEL", append([]interface{}{this.key(userId)}, stale...)...)
+	}
+	return list, nil
+}
+
+func (this *RedisSessionIndex) Delete(userId string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{this.key(userId)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	conn := this.getConn()
+	defer conn.Close()
+	_, err := conn.Do("HDEL", args...)
+	return err
+}
+
+// Bind the session to the authenticated user, and index it.
+// If session.max_concurrent is greater than 0, the least recently used sessions of the user will be revoked
+// when the number of sessions exceeds it. It should be invoked after the user logged in,
+// with RegenerateSession together.
+func (this *WebController) SetSessionUser(userId string) error {
+	sess := this.GetSession()
+	if sess == nil {
+		return nil
+	}
+
+	// Remove the session from the previous user's index.
+	if previous, ok := sess.Values[sessionUserParam].(string); ok && (previous != userId) && (App.sessionIndex != nil) {
+		if id, ok := sess.Values[sessionIndexParam].(string); ok {
+			App.sessionIndex.Delete(previous, id)
+		}
+	}
+
+	id, ok := sess.Values[sessionIndexParam].(string)
+	if !ok {
+		id = generateSessionId()
+		sess.Values[sessionIndexParam] = id
+	}
+	sess.Values[sessionUserParam] = userId
+
+	if App.sessionIndex == nil {
+		return nil
+	}
+
+	now := time.Now()
+	info := &SessionInfo{
+		Id:        id,
+		UserId:    userId,
+		Created:   now,
+		LastSeen:  now,
+		Ip:        getClientIp(this.Context.Request),
+		UserAgent: this.Context.Request.UserAgent(),
+	}
+	if err := App.sessionIndex.Save(info); err != nil {
+		return err
+	}
+	return limitUserSessions(userId, App.Config.sessionMaxConcurrent, id)
+}
+
+// Get the user ID which the session belongs to, empty string will be returned if the session is anonymous.
+func (this *WebController) GetSessionUser() string {
+	sess := this.GetSession()
+	if sess == nil {
+		return ""
+	}
+	userId, _ := sess.Values[sessionUserParam].(string)
+	return userId
+}
+
+// Get the current session's index ID, empty string will be returned if the session is anonymous.
+func (this *WebController) GetSessionIndexId() string {
+	sess := this.GetSession()
+	if sess == nil {
+		return ""
+	}
+	id, _ := sess.Values[sessionIndexParam].(string)
+	return id
+}
+
+// Revoke all of the sessions of the current user except the current session, such as logging out other devices.
+func (this *WebController) RevokeOtherSessions() error {
+	userId := this.GetSessionUser()
+	if len(userId) == 0 {
+		return nil
+	}
+	return RevokeAllUserSessions(userId, this.GetSessionIndexId())
+}
+
+// Remove the session from the user's index.
+func (this *WebController) unindexSession() {
+	if (App.sessionIndex == nil) || (this.Session == nil) {
+		return
+	}
+	userId, _ := this.Session.Values[sessionUserParam].(string)
+	id, _ := this.Session.Values[sessionIndexParam].(string)
+	if (len(userId) > 0) && (len(id) > 0) {
+		App.sessionIndex.Delete(userId, id)
+	}
+}
+
+// Check whether the indexed session has been revoked, and update its last seen time.
+// True will be returned if the session is anonymous or still valid, the session will be kept valid
+// if the index is unavailable.
+func (this *WebController) checkSessionIndex() bool {
+	if (App.sessionIndex == nil) || (this.Session == nil) {
+		return true
+	}
+	userId, _ := this.Session.Values[sessionUserParam].(string)
+	id, _ := this.Session.Values[sessionIndexParam].(string)
+	if (len(userId) == 0) || (len(id) == 0) {
+		return true
+	}
+
+	info, err := App.sessionIndex.Get(userId, id)
+	if err != nil {
+		if this.Log != nil {
+			this.Log.Error("Unable to check the session index: " + err.Error())
+		}
+		return true
+	}
+	if info == nil {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(info.LastSeen) >= sessionIndexTouchInterval*time.Second {
+		info.LastSeen = now
+		info.Ip = getClientIp(this.Context.Request)
+		info.UserAgent = this.Context.Request.UserAgent()
+		App.sessionIndex.Save(info)
+	}
+	return true
+}
@@ -0,0 +1,418 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"github.com/go-language/session"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Save a session with a value, and then get it by the response's cookie.
+func testSessionStore(t *testing.T, store session.Store) {
+	r := httptest.NewRequest("GET", "/", nil)
+	s, err := store.Get(r, "GOSESSION")
+	if err != nil {
+		t.Fatalf("Get session failed: %s", err)
+	}
+	if !s.IsNew {
+		t.Errorf("The session should be new if the request has no cookie.")
+	}
+
+	s.Values["user"] = "cheetah"
+	w := httptest.NewRecorder()
+	if err = s.Save(w); err != nil {
+		t.Fatalf("Save session failed: %s", err)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("The session cookie should be set.")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	s, err = store.Get(r, "GOSESSION")
+	if err != nil {
+		t.Fatalf("Get session failed: %s", err)
+	}
+	if s.IsNew || (s.Values["user"] != "cheetah") {
+		t.Errorf("The session's value should be \"cheetah\".\nthe wrong result: %v", s.Values["user"])
+	}
+
+	// The tampered cookie will be ignored.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "GOSESSION", Value: cookies[0].Value + "x"})
+	s, _ = store.Get(r, "GOSESSION")
+	if !s.IsNew || (len(s.Values) != 0) {
+		t.Errorf("The session should be new if the cookie is tampered.")
+	}
+
+	// Delete the session.
+	s, _ = store.Get(r, "GOSESSION")
+	s.Options.MaxAge = -1
+	w = httptest.NewRecorder()
+	if err = s.Save(w); err != nil {
+		t.Fatalf("Delete session failed: %s", err)
+	}
+	if cookies = w.Result().Cookies(); (len(cookies) != 1) || (cookies[0].MaxAge >= 0) {
+		t.Errorf("The session cookie should be deleted.")
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	testSessionStore(t, NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0))
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	testSessionStore(t, NewCookieSessionStore([]byte("0123456789abcdef"), session.Options{Path: "/", MaxAge: 3600}))
+}
+
+func TestFileSessionStore(t *testing.T) {
+	testSessionStore(t, NewFileSessionStore(t.TempDir(), session.Options{Path: "/", MaxAge: 3600}, 0))
+}
+
+type SessionController struct {
+	WebController
+}
+
+func (this *SessionController) ActionIndex() {
+	this.RenderText("index")
+}
+
+func (this *SessionController) ActionLogin() {
+	this.GetSession().Values["user"] = "cheetah"
+	this.RenderText("login")
+}
+
+func (this *SessionController) ActionRegenerate() {
+	this.RegenerateSession()
+	this.RenderText("regenerate")
+}
+
+func (this *SessionController) ActionLogout() {
+	this.DestroySession()
+	this.RenderText("logout")
+}
+
+func (this *SessionController) ActionSetFlash() {
+	this.AddFlash(FlashSuccess, "saved")
+	this.AddFlash(FlashSuccess, "published")
+	this.RenderText("flash")
+}
+
+func (this *SessionController) ActionGetFlash() {
+	this.RenderText(strings.Join(this.GetFlashes(FlashSuccess), ","))
+}
+
+func (this *SessionController) ActionRenderPlain() {
+	this.ViewEngine = NewHtmlEngine()
+	this.RenderData("plain")
+}
+
+func (this *SessionController) ActionRenderFlashes() {
+	this.ViewEngine = NewHtmlEngine()
+	this.RenderData(`{{range flashes}}{{.message}};{{end}}|{{range flashes}}{{.message}};{{end}}`)
+}
+
+// Serve the action of SessionController with the session store.
+func serveSession(store session.Store, action string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	app := App
+	defer func() {
+		App = app
+	}()
+
+	App = NewApplication()
+	App.Config.enableLog = false
+	App.Config.enableCsrfValidation = false
+	App.sessionStore = store
+
+	info := &ControllerInfo{
+		Route:          "/session",
+		ActionFullName: "Action" + action,
+		ActionName:     action,
+	}
+	handle := generateRouteHandle(info.Route, reflect.TypeOf(SessionController{}), info)
+
+	r := httptest.NewRequest("GET", info.Route, nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handle(w, r, httprouter.Params{})
+	return w
+}
+
+// The session store which counts the sessions loaded from it.
+type countingSessionStore struct {
+	session.Store
+	gets int
+}
+
+func (this *countingSessionStore) Get(r *http.Request, name string) (*session.Session, error) {
+	this.gets++
+	return this.Store.Get(r, name)
+}
+
+func TestLazySession(t *testing.T) {
+	store := NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+
+	if w := serveSession(store, "Index"); len(w.Header().Get("Set-Cookie")) > 0 {
+		t.Errorf("The session should not be saved if it is not accessed.")
+	}
+
+	// The session is loaded from the store only when it is accessed, even if the request carries the cookie.
+	counting := &countingSessionStore{Store: store}
+	cookie := serveSession(store, "Login").Result().Cookies()[0]
+	serveSession(counting, "Index", cookie)
+	if counting.gets != 0 {
+		t.Errorf("The session should not be loaded if it is not accessed.\nthe wrong result: %d loads", counting.gets)
+	}
+	if body := serveSession(counting, "Profile", cookie).Body.String(); (body != "cheetah") || (counting.gets != 1) {
+		t.Errorf("The session should be loaded once when it is accessed.\nthe wrong result: %s, %d loads", body, counting.gets)
+	}
+
+	w := serveSession(store, "Login")
+	if len(w.Header().Get("Set-Cookie")) == 0 {
+		t.Fatalf("The session should be saved if it is modified.")
+	}
+	if !strings.HasSuffix(w.Header().Get("Set-Cookie"), "; SameSite=Lax") {
+		t.Errorf("The session cookie should have the SameSite attribute.\nthe wrong result: %s", w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestRegenerateSession(t *testing.T) {
+	store := NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+
+	old := serveSession(store, "Login").Result().Cookies()[0]
+	cookies := serveSession(store, "Regenerate", old).Result().Cookies()
+	if (len(cookies) != 1) || (cookies[0].Value == old.Value) {
+		t.Fatalf("The session ID should be regenerated.")
+	}
+
+	store.mutex.RLock()
+	_, oldExists := store.sessions[old.Value]
+	regenerated, newExists := store.sessions[cookies[0].Value]
+	store.mutex.RUnlock()
+	if oldExists {
+		t.Errorf("The old session should be deleted.")
+	}
+	if !newExists || (regenerated.values["user"] != "cheetah") {
+		t.Errorf("The values should be migrated to the new session.")
+	}
+
+	cookies = serveSession(store, "Logout", cookies[0]).Result().Cookies()
+	if (len(cookies) != 1) || (cookies[0].MaxAge >= 0) {
+		t.Errorf("The session cookie should be deleted after destroying the session.")
+	}
+	if len(store.sessions) != 0 {
+		t.Errorf("The session should be deleted from the store after destroying the session.")
+	}
+}
+
+func TestFlash(t *testing.T) {
+	store := NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+
+	cookie := serveSession(store, "SetFlash").Result().Cookies()[0]
+	if body := serveSession(store, "GetFlash", cookie).Body.String(); body != "saved,published" {
+		t.Errorf("The flash messages should be \"saved,published\".\nthe wrong result: \"%s\"", body)
+	}
+	if body := serveSession(store, "GetFlash", cookie).Body.String(); body != "" {
+		t.Errorf("The flash messages should be cleared after being read.\nthe wrong result: \"%s\"", body)
+	}
+
+	// The flash messages are only loaded by the views which render them.
+	cookie = serveSession(store, "SetFlash", cookie).Result().Cookies()[0]
+	if w := serveSession(store, "RenderPlain", cookie); len(w.Header().Get("Set-Cookie")) > 0 {
+		t.Errorf("The flash messages should not be cleared by the view which does not render them.")
+	}
+	if body := serveSession(store, "RenderFlashes", cookie).Body.String(); body != "saved;published;|saved;published;" {
+		t.Errorf("The flash messages should be rendered.\nthe wrong result: \"%s\"", body)
+	}
+	if body := serveSession(store, "GetFlash", cookie).Body.String(); body != "" {
+		t.Errorf("The flash messages should be cleared after being rendered.\nthe wrong result: \"%s\"", body)
+	}
+}
+
+func TestLimitUserSessions(t *testing.T) {
+	index := App.sessionIndex
+	defer func() {
+		App.sessionIndex = index
+	}()
+	App.sessionIndex = NewMemorySessionIndex(time.Hour)
+
+	now := time.Now()
+	for i, id := range []string{"a", "b", "c", "d"} {
+		App.sessionIndex.Save(&SessionInfo{Id: id, UserId: "1", LastSeen: now.Add(time.Duration(i) * time.Minute)})
+	}
+
+	// The session "a" is the least recently used, but it should be kept.
+	if err := limitUserSessions("1", 2, "a"); err != nil {
+		t.Fatal(err)
+	}
+	sessions, _ := ListUserSessions("1")
+	if (len(sessions) != 2) || (sessions[0].Id != "d") || (sessions[1].Id != "a") {
+		t.Errorf("The sessions should be [d a].\nthe wrong result: %v", sessions)
+	}
+
+	RevokeAllUserSessions("1", "a")
+	if sessions, _ = ListUserSessions("1"); (len(sessions) != 1) || (sessions[0].Id != "a") {
+		t.Errorf("Only the session \"a\" should be kept.\nthe wrong result: %v", sessions)
+	}
+}
+
+func (this *SessionController) ActionProfile() {
+	user, _ := this.GetSession().Values["user"].(string)
+	this.RenderText(user)
+}
+
+func TestSessionRefresh(t *testing.T) {
+	store := NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+	cookie := serveSession(store, "Login").Result().Cookies()[0]
+
+	w := serveSession(store, "Profile", cookie)
+	if w.Body.String() != "cheetah" {
+		t.Errorf("The session's user should be \"cheetah\".\nthe wrong result: \"%s\"", w.Body.String())
+	}
+	if len(w.Header().Get("Set-Cookie")) > 0 {
+		t.Errorf("The session should not be saved if it was refreshed recently.")
+	}
+
+	// The read-only session will be saved when its last activity time is older than a tenth of the max age.
+	store.mutex.Lock()
+	store.sessions[cookie.Value].values[sessionActivityParam] = time.Now().Unix() - 3600/10
+	store.mutex.Unlock()
+	if w = serveSession(store, "Profile", cookie); len(w.Header().Get("Set-Cookie")) == 0 {
+		t.Errorf("The active session should be saved to extend its expiry.")
+	}
+}
+
+// The session store which does not implement SessionDeleter.
+type saveOnlySessionStore struct {
+	store *MemorySessionStore
+}
+
+func (this *saveOnlySessionStore) Get(r *http.Request, name string) (*session.Session, error) {
+	return this.store.Get(r, name)
+}
+
+func (this *saveOnlySessionStore) New(r *http.Request, name string) (*session.Session, error) {
+	return this.store.New(r, name)
+}
+
+func (this *saveOnlySessionStore) Save(w http.ResponseWriter, s *session.Session) error {
+	return this.store.Save(w, s)
+}
+
+func TestRegenerateSessionWithoutDeleter(t *testing.T) {
+	memoryStore := NewMemorySessionStore(session.Options{Path: "/", MaxAge: 3600}, 0)
+	store := &saveOnlySessionStore{memoryStore}
+
+	old := serveSession(store, "Login").Result().Cookies()[0]
+	cookies := serveSession(store, "Regenerate", old).Result().Cookies()
+	if (len(cookies) != 1) || (cookies[0].Value == old.Value) {
+		t.Fatalf("The session ID should be regenerated.")
+	}
+	memoryStore.mutex.RLock()
+	_, oldExists := memoryStore.sessions[old.Value]
+	memoryStore.mutex.RUnlock()
+	if oldExists {
+		t.Errorf("The old session should be deleted by saving it with a negative max age.")
+	}
+
+	serveSession(store, "Logout", cookies[0])
+	if len(memoryStore.sessions) != 0 {
+		t.Errorf("The session should be deleted from the store after destroying the session.")
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	config := App.Config
+	defer func() {
+		App.Config = config
+	}()
+	_config := *config
+	App.Config = &_config
+	App.Config.sessionIdleTimeout = 600
+	App.Config.sessionAbsoluteTimeout = 3600
+
+	now := time.Now().Unix()
+	cases := []struct {
+		created  int64
+		activity int64
+		timeout  bool
+	}{
+		{now - 60, now - 60, false},
+		{now - 1800, now - 601, true},
+		{now - 3601, now - 60, true},
+	}
+	for _, c := range cases {
+		s := session.NewSession(nil, "session")
+		s.Values[sessionCreatedParam] = c.created
+		s.Values[sessionActivityParam] = c.activity
+		if timeout := isSessionTimeout(s, now); timeout != c.timeout {
+			t.Errorf("The session created at %d and active at %d should be timeout: %t.\nthe wrong result: %t", now-c.created, now-c.activity, c.timeout, timeout)
+		}
+	}
+
+	// The timestamps of the new session will be set when it is saved.
+	s := session.NewSession(nil, "session")
+	touchSession(s, now)
+	if (s.Values[sessionCreatedParam] != now) || (s.Values[sessionActivityParam] != now) {
+		t.Errorf("The session's timestamps should be set.\nthe wrong result: %v", s.Values)
+	}
+}
+
+func TestSessionConfigRefusals(t *testing.T) {
+	cases := []struct {
+		name   string
+		config func(app *Application)
+	}{
+		{"SameSite=None without Secure", func(app *Application) {
+			app.Config.sessionCookieSameSite = "None"
+		}},
+		{"insecure cookie under HTTPS", func(app *Application) {
+			app.Config.serverProtocol = "HTTPS"
+		}},
+		{"unsupported SameSite", func(app *Application) {
+			app.Config.sessionCookieSameSite = "Loose"
+		}},
+		{"idle timeout greater than absolute timeout", func(app *Application) {
+			app.Config.sessionIdleTimeout = 7200
+			app.Config.sessionAbsoluteTimeout = 3600
+		}},
+	}
+	for _, c := range cases {
+		app := NewApplication()
+		app.basePath = "/tmp"
+		app.mode = ModePro
+		app.Config.enableSession = true
+		app.Config.sessionStore = SessionStoreMemory
+		c.config(&app)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("The configuration should be refused: %s.", c.name)
+				}
+			}()
+			app.validateConfig()
+		}()
+	}
+
+	// The secure configuration is accepted.
+	app := NewApplication()
+	app.basePath = "/tmp"
+	app.mode = ModePro
+	app.Config.enableSession = true
+	app.Config.sessionStore = SessionStoreMemory
+	app.Config.serverProtocol = "HTTPS"
+	app.Config.sessionCookieSecure = true
+	app.Config.sessionCookieSameSite = "None"
+	app.validateConfig()
+}
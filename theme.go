@@ -0,0 +1,168 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+)
+
+// The directory of the theme's static resources.
+const themeResourceDir = "resources"
+
+// Theme overrides the views, layouts, widgets and static resources of the application,
+// the files which do not exist in the theme fall back to the originals.
+// The views of the theme are in the views' directory of the theme, such as "views/index/index.html"
+// overrides the view "index/index.html", and the static resources are in the "resources" directory.
+type Theme struct {
+	Name    string
+	fsys    fs.FS
+	mutex   sync.Mutex
+	engines map[ViewEngine]ViewEngine
+}
+
+// Create a theme, the files are read from fsys, such as embed.FS.
+// They are read from the directory "{theme.dir}/{name}" under the application's base path if fsys is nil.
+func NewTheme(name string, fsys fs.FS) *Theme {
+	return &Theme{
+		Name:    name,
+		fsys:    fsys,
+		engines: make(map[ViewEngine]ViewEngine),
+	}
+}
+
+// Register the theme, it should be invoked before running the application.
+// The themes require the view file system, see also SetViewFS and the view.search_path configuration.
+func RegisterTheme(theme *Theme) {
+	App.themes[theme.Name] = theme
+}
+
+// Get the registered theme by name, nil will be returned if the name is empty.
+func (this *Application) getTheme(name string) (*Theme, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	theme, ok := this.themes[name]
+	if !ok {
+		return nil, errors.New("The theme is not registered: " + name)
+	}
+	return theme, nil
+}
+
+func (this *Theme) getFS() fs.FS {
+	if this.fsys != nil {
+		return this.fsys
+	}
+	return os.DirFS(path.Join(App.basePath, App.Config.themeDir, this.Name))
+}
+
+// Get the file system of the theme's views, the views fall back to the fsys.
+func (this *Theme) getViewFS(fsys fs.FS) fs.FS {
+	themeFS := NewViewFS(this.getFS(), App.Config.viewDir)
+	if fsys == nil {
+		return themeFS
+	}
+	return overlayFS{themeFS, fsys}
+}
+
+// Get the file system of the theme's static resources, the resources fall back to the fsys.
+func (this *Theme) getResourceFS(fsys fs.FS) fs.FS {
+	themeFS := NewViewFS(this.getFS(), themeResourceDir)
+	if fsys == nil {
+		return themeFS
+	}
+	return overlayFS{themeFS, fsys}
+}
+
+// Get the view engine which renders the theme's views, it is created for every engine,
+// because the parsed views are cached by the file names.
+// The custom view engines are not themed, they are returned as it is.
+func (this *Theme) getViewEngine(engine ViewEngine) ViewEngine {
+	switch engine.(type) {
+	case *MustacheEngine, *HtmlEngine:
+	default:
+		return engine
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if themed, ok := this.engines[engine]; ok {
+		return themed
+	}
+
+	var themed ViewEngine
+	switch e := engine.(type) {
+	case *MustacheEngine:
+		mustacheEngine := NewMustacheEngine()
+		mustacheEngine.SetFS(this.getViewFS(e.getFS()))
+		themed = mustacheEngine
+	case *HtmlEngine:
+		htmlEngine := NewHtmlEngine()
+		htmlEngine.Funcs = e.Funcs
+		htmlEngine.SetFS(this.getViewFS(e.getFS()))
+		themed = htmlEngine
+	}
+	this.engines[engine] = themed
+	return themed
+}
+
+// Set the theme of the host, the empty name means no theme.
+// It can be overridden by the controller's SetTheme method.
+// An error will be returned if the theme is not registered, and the theme will not be changed.
+func (this *Host) SetTheme(name string) error {
+	theme, err := App.getTheme(name)
+	if err != nil {
+		return err
+	}
+	this.theme = theme
+	return nil
+}
+
+// Register the static resources which are overridden by the host's theme, the path is the original resources' directory.
+// The asset bundles which are served by it should be themed, see also AssetBundle.Themed.
+func (this *Host) RegisterThemeResources(route, path string) {
+	var mutex sync.Mutex
+	// The handles of the themes, so that the fingerprints of the resources are cached.
+	handles := make(map[*Theme]httprouter.Handle)
+	this.router.GET("/"+route+"/*filepath", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mutex.Lock()
+		handle, ok := handles[this.theme]
+		if !ok {
+			fsys := os.DirFS(path)
+			if this.theme != nil {
+				fsys = this.theme.getResourceFS(fsys)
+			}
+			handle = newResourceHandle(http.FS(fsys))
+			handles[this.theme] = handle
+		}
+		mutex.Unlock()
+		handle(w, r, ps)
+	})
+}
+
+// Set the theme of the request, the empty name means no theme.
+// It overrides the host's theme, and it should be invoked before rendering.
+// The error is returned if the theme is not registered, such as the name is chosen by the user,
+// and the theme is not changed.
+func (this *WebController) SetTheme(name string) error {
+	theme, err := App.getTheme(name)
+	if err != nil {
+		return err
+	}
+	this.Theme = theme
+	if this.View != nil {
+		this.View.theme = theme
+	}
+	this.ViewEngine = this.viewEngine
+	if this.Theme != nil {
+		this.ViewEngine = this.Theme.getViewEngine(this.viewEngine)
+	}
+	return nil
+}
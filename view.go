@@ -1,13 +1,32 @@
 package cheetah
 
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+// View is used to build the head and footer of the page, it is created for every request,
+// the actions populate it, and it is exposed to the layout as {{{head}}} and {{{footer}}} with mustache,
+// and {{head}} and {{footer}} with HtmlEngine.
+// The duplicate assets are rendered only once.
 type View struct {
 	Title       string // page's title
 	Keywords    string // page's keywords
 	Description string // page's description
+	Metas       []*Meta
 	HeaderCss   []*CssAsset
 	HeaderJs    []*JsAsset
 	FooterCss   []*CssAsset
 	FooterJs    []*JsAsset
+	bundles     []string
+	theme       *Theme // the theme of the request, it overrides the files of the themed bundles.
+}
+
+// Meta tag, such as <meta name="author" content="HeadwindFly"/>.
+type Meta struct {
+	Name    string
+	Content string
 }
 
 func NewView(title, keywords, description string) *View {
@@ -15,9 +34,189 @@ func NewView(title, keywords, description string) *View {
 		Title:       title,
 		Keywords:    keywords,
 		Description: description,
+		Metas:       make([]*Meta, 0),
 		HeaderCss:   make([]*CssAsset, 0),
 		HeaderJs:    make([]*JsAsset, 0),
 		FooterCss:   make([]*CssAsset, 0),
 		FooterJs:    make([]*JsAsset, 0),
+		bundles:     make([]string, 0),
+	}
+}
+
+// Register the asset bundles which are registered by RegisterAssetBundle,
+// their assets are rendered before the view's assets in dependency order.
+// It panics if a bundle is not registered, so that the error is reported by the action instead of rendering.
+func (this *View) RegisterAssetBundle(names ...string) *View {
+	for _, name := range names {
+		if _, ok := App.assetBundles[name]; !ok {
+			panic("The asset bundle is not registered: " + name)
+		}
+	}
+	this.bundles = append(this.bundles, names...)
+	return this
+}
+
+// The assets of the head and footer blocks, they are resolved once for rendering both of the blocks.
+type viewAssets struct {
+	headerCss []*CssAsset
+	headerJs  []*JsAsset
+	footerCss []*CssAsset
+	footerJs  []*JsAsset
+}
+
+// Get the assets of the head and footer blocks, including the assets of the bundles.
+// The bundles which failed to be resolved are skipped, their dependencies are validated when running the application.
+func (this *View) getAssets() *viewAssets {
+	assets := &viewAssets{}
+	bundles, _ := resolveAssetBundles(this.bundles)
+	for _, bundle := range bundles {
+		assets.headerCss = append(assets.headerCss, bundle.cssAssets(this.theme)...)
+		if bundle.JsInHead {
+			assets.headerJs = append(assets.headerJs, bundle.jsAssets(this.theme)...)
+		} else {
+			assets.footerJs = append(assets.footerJs, bundle.jsAssets(this.theme)...)
+		}
+	}
+	assets.headerCss = append(assets.headerCss, this.HeaderCss...)
+	assets.headerJs = append(assets.headerJs, this.HeaderJs...)
+	assets.footerCss = append(assets.footerCss, this.FooterCss...)
+	assets.footerJs = append(assets.footerJs, this.FooterJs...)
+	return assets
+}
+
+// Set the meta tag, the meta tag which has the same name will be replaced.
+func (this *View) SetMeta(name, content string) *View {
+	for _, meta := range this.Metas {
+		if meta.Name == name {
+			meta.Content = content
+			return this
+		}
+	}
+	this.Metas = append(this.Metas, &Meta{Name: name, Content: content})
+	return this
+}
+
+func (this *View) AddHeaderCss(asset *CssAsset) *View {
+	this.HeaderCss = append(this.HeaderCss, asset)
+	return this
+}
+
+func (this *View) AddHeaderJs(asset *JsAsset) *View {
+	this.HeaderJs = append(this.HeaderJs, asset)
+	return this
+}
+
+func (this *View) AddFooterCss(asset *CssAsset) *View {
+	this.FooterCss = append(this.FooterCss, asset)
+	return this
+}
+
+func (this *View) AddFooterJs(asset *JsAsset) *View {
+	this.FooterJs = append(this.FooterJs, asset)
+	return this
+}
+
+// Render the head block, including the title, meta tags, and the header CSS and JavaScript.
+func (this *View) Head() string {
+	return this.head(this.getAssets())
+}
+
+func (this *View) head(assets *viewAssets) string {
+	lines := []string{}
+	if len(this.Title) > 0 {
+		lines = append(lines, "<title>"+html.EscapeString(this.Title)+"</title>")
+	}
+
+	metas := []*Meta{}
+	if len(this.Keywords) > 0 {
+		metas = append(metas, &Meta{Name: "keywords", Content: this.Keywords})
+	}
+	if len(this.Description) > 0 {
+		metas = append(metas, &Meta{Name: "description", Content: this.Description})
+	}
+	for _, meta := range append(metas, this.Metas...) {
+		lines = append(lines, "<meta name=\""+html.EscapeString(meta.Name)+"\" content=\""+html.EscapeString(meta.Content)+"\"/>")
+	}
+
+	rendered := make(map[string]bool)
+	lines = appendAssets(lines, rendered, cssAssets(assets.headerCss))
+	lines = appendAssets(lines, rendered, jsAssets(assets.headerJs))
+	return strings.Join(lines, "\n")
+}
+
+// Render the footer block, including the footer CSS and JavaScript.
+// The assets which have been rendered in the head block are skipped.
+func (this *View) Footer() string {
+	return this.footer(this.getAssets())
+}
+
+func (this *View) footer(assets *viewAssets) string {
+	rendered := make(map[string]bool)
+	appendAssets(nil, rendered, cssAssets(assets.headerCss))
+	appendAssets(nil, rendered, jsAssets(assets.headerJs))
+
+	lines := []string{}
+	lines = appendAssets(lines, rendered, cssAssets(assets.footerCss))
+	lines = appendAssets(lines, rendered, jsAssets(assets.footerJs))
+	return strings.Join(lines, "\n")
+}
+
+// The placeholders of the head and footer blocks, they are replaced after rendering,
+// so that the assets registered while rendering, such as by the widgets, are included.
+const (
+	viewHeadPlaceholder   = "<![CDATA[CHEETAH-BLOCK-HEAD]]>"
+	viewFooterPlaceholder = "<![CDATA[CHEETAH-BLOCK-FOOTER]]>"
+)
+
+// Returns the context which exposes the placeholders of the head and footer blocks to the view and layout.
+func (this *View) context() map[string]interface{} {
+	return map[string]interface{}{
+		"head":   template.HTML(viewHeadPlaceholder),
+		"footer": template.HTML(viewFooterPlaceholder),
+	}
+}
+
+// Replace the placeholders of the head and footer blocks in the rendered page,
+// the assets are resolved and hashed once for both of the blocks.
+func (this *View) replaceBlocks(body string) string {
+	hasHead, hasFooter := strings.Contains(body, viewHeadPlaceholder), strings.Contains(body, viewFooterPlaceholder)
+	if !hasHead && !hasFooter {
+		return body
+	}
+	assets := this.getAssets()
+	if hasHead {
+		body = strings.Replace(body, viewHeadPlaceholder, this.head(assets), -1)
+	}
+	if hasFooter {
+		body = strings.Replace(body, viewFooterPlaceholder, this.footer(assets), -1)
+	}
+	return body
+}
+
+// Append the outputs of the assets which have not been rendered.
+func appendAssets(lines []string, rendered map[string]bool, assets []Asset) []string {
+	for _, asset := range assets {
+		output := asset.Output()
+		if !rendered[output] {
+			rendered[output] = true
+			lines = append(lines, output)
+		}
+	}
+	return lines
+}
+
+func cssAssets(assets []*CssAsset) []Asset {
+	list := make([]Asset, len(assets))
+	for i, asset := range assets {
+		list[i] = asset
+	}
+	return list
+}
+
+func jsAssets(assets []*JsAsset) []Asset {
+	list := make([]Asset, len(assets))
+	for i, asset := range assets {
+		list[i] = asset
 	}
+	return list
 }
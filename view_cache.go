@@ -0,0 +1,192 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The view engine which supports precompiling the view files, the errors will be reported before serving.
+type ViewPrecompiler interface {
+	// Parse the view file and cache it.
+	Precompile(file string) error
+}
+
+// The view engine which supports precompiling the view files in the layouts,
+// it is used if the view and layout are parsed together, such as HtmlEngine.
+type ViewLayoutPrecompiler interface {
+	// Parse the view file in the layout file and cache it.
+	PrecompileInLayout(file string, layout string) error
+}
+
+// The cache of the parsed templates, the key is the files which the template is parsed from.
+// If reload is true, the template will be parsed again when any file's modification time changed,
+// so that the changes show up without restarting, it is used in ModeDev.
+type viewCache struct {
+	reload bool
+	mutex  sync.RWMutex
+	items  map[string]*viewCacheItem
+}
+
+type viewCacheItem struct {
+	template interface{}
+	modTimes []time.Time
+}
+
+func newViewCache(reload bool) *viewCache {
+	return &viewCache{
+		reload: reload,
+		items:  make(map[string]*viewCacheItem),
+	}
+}
+
+// Get the template parsed from the files of fsys, it will be parsed by parse if it has not been cached or it is out of date.
+func (this *viewCache) get(fsys fs.FS, files []string, parse func() (interface{}, error)) (interface{}, error) {
+	key := strings.Join(files, "\x00")
+
+	this.mutex.RLock()
+	item, ok := this.items[key]
+	reload := this.reload
+	this.mutex.RUnlock()
+
+	var modTimes []time.Time
+	if reload {
+		var err error
+		if modTimes, err = getModTimes(fsys, files); err != nil {
+			return nil, err
+		}
+		if ok && !isModTimesEqual(item.modTimes, modTimes) {
+			ok = false
+		}
+	}
+	if ok {
+		return item.template, nil
+	}
+
+	template, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	this.items[key] = &viewCacheItem{template: template, modTimes: modTimes}
+	this.mutex.Unlock()
+	return template, nil
+}
+
+func (this *viewCache) setReload(reload bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.reload = reload
+}
+
+func getModTimes(fsys fs.FS, files []string) ([]time.Time, error) {
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := statViewFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func isModTimesEqual(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Precompile the view files of all the controllers, including the layouts,
+// and the views in the controllers' default layouts if the engine parses them together.
+// The errors of all files are reported together.
+func (this *Application) precompileViews() error {
+	files := make(map[ViewEngine]map[string]bool)
+	layouts := make(map[ViewEngine]map[[2]string]bool)
+	fsys := this.getViewFS()
+	addDir := func(engine ViewEngine, dir string) []string {
+		entries, err := readViewDir(fsys, dir)
+		if err != nil {
+			return nil
+		}
+		if _, ok := files[engine]; !ok {
+			files[engine] = make(map[string]bool)
+		}
+		added := []string{}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), this.Config.viewSuffix) {
+				file := path.Join(dir, entry.Name())
+				files[engine][file] = true
+				added = append(added, file)
+			}
+		}
+		return added
+	}
+
+	for _, host := range this.hosts {
+		for _, route := range host.routes {
+			info := route.ControllerInfo
+			engine := info.ViewEngine
+			if engine == nil {
+				engine = this.getViewEngine()
+			}
+			views := addDir(engine, info.ViewPath)
+			addDir(engine, path.Join(path.Dir(info.ViewPath), this.Config.viewLayoutDir))
+			addDir(engine, path.Join(path.Dir(info.ViewPath), this.Config.viewWidgetDir))
+
+			layout := path.Join(path.Dir(info.ViewPath), this.Config.viewLayoutDir, info.Layout)
+			if (len(info.Layout) == 0) || !isViewFile(fsys, layout) {
+				continue
+			}
+			if _, ok := layouts[engine]; !ok {
+				layouts[engine] = make(map[[2]string]bool)
+			}
+			for _, view := range views {
+				layouts[engine][[2]string{view, layout}] = true
+			}
+		}
+	}
+
+	messages := []string{}
+	for engine, engineFiles := range files {
+		precompiler, ok := engine.(ViewPrecompiler)
+		if !ok {
+			continue
+		}
+		for file := range engineFiles {
+			if err := precompiler.Precompile(file); err != nil {
+				messages = append(messages, file+": "+err.Error())
+			}
+		}
+	}
+	for engine, pairs := range layouts {
+		precompiler, ok := engine.(ViewLayoutPrecompiler)
+		if !ok {
+			continue
+		}
+		for pair := range pairs {
+			if err := precompiler.PrecompileInLayout(pair[0], pair[1]); err != nil {
+				messages = append(messages, pair[0]+" in "+pair[1]+": "+err.Error())
+			}
+		}
+	}
+	if len(messages) > 0 {
+		sort.Strings(messages)
+		return errors.New("Failed to precompile views:\n" + strings.Join(messages, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	ViewEngineMustache = "MUSTACHE"
+	ViewEngineHtml     = "HTML"
+)
+
+// View engine interface.
+// The context is a list of values, such as maps and structs, the former value takes precedence.
+type ViewEngine interface {
+	// Render the template string.
+	Render(data string, context ...interface{}) (string, error)
+
+	// Render the view file.
+	RenderFile(file string, context ...interface{}) (string, error)
+
+	// Render the view file in the layout file.
+	RenderFileInLayout(file string, layout string, context ...interface{}) (string, error)
+}
+
+// Create the view engine according to the view.engine configuration.
+func (this *Application) newViewEngine() ViewEngine {
+	switch strings.ToUpper(this.Config.viewEngine) {
+	case ViewEngineMustache:
+		return NewMustacheEngine()
+	case ViewEngineHtml:
+		return NewHtmlEngine()
+	}
+	panic("The view engine is not supported: " + this.Config.viewEngine + ", only support MUSTACHE and HTML.")
+}
+
+// The mutex of the application's view engine, because it may be created on demand.
+var viewEngineMutex sync.Mutex
+
+// Get the view engine, the engine will be created according to the configuration and kept if it has not been set,
+// such as rendering the error views before running the application.
+func (this *Application) getViewEngine() ViewEngine {
+	viewEngineMutex.Lock()
+	defer viewEngineMutex.Unlock()
+	if this.viewEngine == nil {
+		this.viewEngine = this.newViewEngine()
+	}
+	return this.viewEngine
+}
+
+// Mustache view engine.
+// The view's content is exposed to the layout as {{{content}}}.
+// The parsed view files are cached, and they will be reloaded when they changed in ModeDev.
+// The view files are read from the file system which set by SetFS, it is the application's view file system default.
+// The partials, such as {{> header}}, are relative to the view's directory, and they are inlined when the view is parsed,
+// so the changes of the partials show up after the view is reloaded.
+type MustacheEngine struct {
+	fsys  fs.FS
+	cache *viewCache
+}
+
+func NewMustacheEngine() *MustacheEngine {
+	return &MustacheEngine{
+		cache: newViewCache(App.mode == ModeDev),
+	}
+}
+
+// Set whether to reload the changed view files.
+func (this *MustacheEngine) SetReload(reload bool) {
+	this.cache.setReload(reload)
+}
+
+// Set the file system which the view files are read from.
+func (this *MustacheEngine) SetFS(fsys fs.FS) {
+	this.fsys = fsys
+}
+
+func (this *MustacheEngine) getFS() fs.FS {
+	if this.fsys != nil {
+		return this.fsys
+	}
+	return App.getViewFS()
+}
+
+func (this *MustacheEngine) parseFile(file string) (*mustacheTemplate, error) {
+	fsys := this.getFS()
+	tmpl, err := this.cache.get(fsys, []string{file}, func() (interface{}, error) {
+		data, err := readViewFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = inlineMustachePartials(fsys, path.Dir(file), data, 0); err != nil {
+			return nil, err
+		}
+		return parseMustache(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.(*mustacheTemplate), nil
+}
+
+// The max depth of the nested partials, it prevents the recursive partials.
+const mustacheMaxPartialDepth = 10
+
+var mustachePartialRegexp = regexp.MustCompile(`\{\{>\s*([^\s}]+)\s*\}\}`)
+
+// Inline the partials of the mustache template, the partial's name is relative to the dir,
+// and the view suffix can be omitted, such as {{> header}} includes "header.html".
+func inlineMustachePartials(fsys fs.FS, dir string, data string, depth int) (string, error) {
+	var err error
+	data = mustachePartialRegexp.ReplaceAllStringFunc(data, func(tag string) string {
+		if err != nil {
+			return ""
+		}
+		if depth >= mustacheMaxPartialDepth {
+			err = errors.New("The partials are nested too deeply: " + tag)
+			return ""
+		}
+
+		name := path.Join(dir, mustachePartialRegexp.FindStringSubmatch(tag)[1])
+		if !isViewFile(fsys, name) {
+			name += App.Config.viewSuffix
+		}
+		var partial string
+		if partial, err = readViewFile(fsys, name); err != nil {
+			return ""
+		}
+		partial, err = inlineMustachePartials(fsys, path.Dir(name), partial, depth+1)
+		return partial
+	})
+	return data, err
+}
+
+func (this *MustacheEngine) Precompile(file string) error {
+	_, err := this.parseFile(file)
+	return err
+}
+
+func (this *MustacheEngine) Render(data string, context ...interface{}) (string, error) {
+	tmpl, err := parseMustache(data)
+	if err != nil {
+		return "", err
+	}
+	return this.render(tmpl, nil, context)
+}
+
+func (this *MustacheEngine) RenderFile(file string, context ...interface{}) (string, error) {
+	tmpl, err := this.parseFile(file)
+	if err != nil {
+		return "", err
+	}
+	return this.render(tmpl, nil, context)
+}
+
+func (this *MustacheEngine) RenderFileInLayout(file string, layout string, context ...interface{}) (string, error) {
+	tmpl, err := this.parseFile(file)
+	if err != nil {
+		return "", err
+	}
+	layoutTmpl, err := this.parseFile(layout)
+	if err != nil {
+		return "", err
+	}
+	return this.render(tmpl, layoutTmpl, context)
+}
+
+// The view scope's values are looked up after the action's context, and the view scope's functions
+// take precedence over the view functions in the lambdas.
+func (this *MustacheEngine) render(tmpl *mustacheTemplate, layout *mustacheTemplate, context []interface{}) (string, error) {
+	funcs := make(template.FuncMap, len(App.viewFuncs))
+	for name, fn := range App.viewFuncs {
+		funcs[name] = fn
+	}
+	context, scope := splitViewScope(context)
+	if scope != nil {
+		for name, fn := range scope.funcs() {
+			funcs[name] = fn
+		}
+		context = append(context, scope.values(func(name string) bool {
+			return tmpl.uses(name) || ((layout != nil) && layout.uses(name))
+		}))
+	}
+	return tmpl.render(layout, funcs, context)
+}
+
+// Go's html/template view engine, the output is escaped contextually.
+// The functions registered by RegisterViewFunc are available, and the engine's Funcs take precedence.
+// The view is parsed as the template named "content", so that the layout renders the view's content
+// by {{template "content" .}}.
+// The action's context is the root value, so that the structs' methods and promoted fields are available,
+// the values of the context are merged into a map if there are more than one value,
+// the maps' entries and the structs' exported fields are merged.
+// The request-scoped values of the controller are the functions, such as {{head}}, {{footer}} and {{csrf.Field}}.
+// The parsed view files are cached, and they will be reloaded when they changed in ModeDev.
+// The view files are read from the file system which set by SetFS, it is the application's view file system default.
+type HtmlEngine struct {
+	Funcs template.FuncMap // the functions which are available in the templates, it must be set before rendering.
+	fsys  fs.FS
+	cache *viewCache
+}
+
+func NewHtmlEngine() *HtmlEngine {
+	return &HtmlEngine{
+		Funcs: make(template.FuncMap),
+		cache: newViewCache(App.mode == ModeDev),
+	}
+}
+
+// Set whether to reload the changed view files.
+func (this *HtmlEngine) SetReload(reload bool) {
+	this.cache.setReload(reload)
+}
+
+// Set the file system which the view files are read from.
+func (this *HtmlEngine) SetFS(fsys fs.FS) {
+	this.fsys = fsys
+}
+
+func (this *HtmlEngine) getFS() fs.FS {
+	if this.fsys != nil {
+		return this.fsys
+	}
+	return App.getViewFS()
+}
+
+func (this *HtmlEngine) Precompile(file string) error {
+	_, err := this.parseFile(file)
+	return err
+}
+
+func (this *HtmlEngine) parseFile(file string) (*htmlTemplate, error) {
+	fsys := this.getFS()
+	tmpl, err := this.cache.get(fsys, []string{file}, func() (interface{}, error) {
+		tmpl, err := this.parse(fsys, template.New(path.Base(file)), file)
+		if err != nil {
+			return nil, err
+		}
+		return &htmlTemplate{template: tmpl, funcs: this.getFuncs()}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.(*htmlTemplate), nil
+}
+
+// The name of the view template in the layout.
+const htmlEngineContent = "content"
+
+// Get the functions of the views, the engine's Funcs take precedence.
+func (this *HtmlEngine) getFuncs() template.FuncMap {
+	funcs := getViewScopePlaceholders()
+	for name, fn := range App.viewFuncs {
+		funcs[name] = fn
+	}
+	for name, fn := range this.Funcs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func (this *HtmlEngine) funcs(tmpl *template.Template) *template.Template {
+	return tmpl.Funcs(this.getFuncs())
+}
+
+func (this *HtmlEngine) parse(fsys fs.FS, tmpl *template.Template, file string) (*template.Template, error) {
+	data, err := readViewFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	return this.funcs(tmpl).Parse(data)
+}
+
+func (this *HtmlEngine) Render(data string, context ...interface{}) (string, error) {
+	tmpl, err := this.funcs(template.New("")).Parse(data)
+	if err != nil {
+		return "", err
+	}
+	context, scope := splitViewScope(context)
+	if scope != nil {
+		tmpl.Funcs(scope.htmlFuncs(tmpl))
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, mergeViewContext(context)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (this *HtmlEngine) RenderFile(file string, context ...interface{}) (string, error) {
+	tmpl, err := this.parseFile(file)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.execute(context)
+}
+
+// The layout and the view are parsed into the same template set, it is cached by both of them.
+func (this *HtmlEngine) parseFileInLayout(file string, layout string) (*htmlTemplate, error) {
+	fsys := this.getFS()
+	tmpl, err := this.cache.get(fsys, []string{layout, file}, func() (interface{}, error) {
+		tmpl, err := this.parse(fsys, template.New(path.Base(layout)), layout)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = this.parse(fsys, tmpl.New(htmlEngineContent), file); err != nil {
+			return nil, err
+		}
+		return &htmlTemplate{template: tmpl, funcs: this.getFuncs()}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.(*htmlTemplate), nil
+}
+
+func (this *HtmlEngine) PrecompileInLayout(file string, layout string) error {
+	_, err := this.parseFileInLayout(file, layout)
+	return err
+}
+
+func (this *HtmlEngine) RenderFileInLayout(file string, layout string, context ...interface{}) (string, error) {
+	tmpl, err := this.parseFileInLayout(file, layout)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.execute(context)
+}
+
+// The parsed template of HtmlEngine, it is never executed, because the template can not be cloned after
+// it has been executed. The clones are executed with the request-scoped functions, and they are reused.
+type htmlTemplate struct {
+	template *template.Template
+	funcs    template.FuncMap // the functions which the template is parsed with.
+	clones   sync.Pool
+}
+
+func (this *htmlTemplate) execute(context []interface{}) (string, error) {
+	tmpl, _ := this.clones.Get().(*template.Template)
+	if tmpl == nil {
+		var err error
+		if tmpl, err = this.template.Clone(); err != nil {
+			return "", err
+		}
+	}
+
+	context, scope := splitViewScope(context)
+	if scope != nil {
+		tmpl.Funcs(scope.htmlFuncs(tmpl))
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, mergeViewContext(context))
+	if scope != nil {
+		// Release the request-scoped values before the clone is reused.
+		tmpl.Funcs(scope.placeholders(this.funcs))
+	}
+	this.clones.Put(tmpl)
+
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Merge the values of the context into a map, the former value takes precedence.
+// The single value is returned directly.
+func mergeViewContext(context []interface{}) interface{} {
+	switch len(context) {
+	case 0:
+		return nil
+	case 1:
+		return context[0]
+	}
+
+	data := make(map[string]interface{})
+	set := func(key string, value interface{}) {
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+	for _, value := range context {
+		v := reflect.ValueOf(value)
+		for v.IsValid() && ((v.Kind() == reflect.Ptr) || (v.Kind() == reflect.Interface)) {
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				continue
+			}
+			for _, key := range v.MapKeys() {
+				set(key.String(), v.MapIndex(key).Interface())
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if field := v.Type().Field(i); len(field.PkgPath) == 0 {
+					set(field.Name, v.Field(i).Interface())
+				}
+			}
+		}
+	}
+	return data
+}
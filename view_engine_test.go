@@ -0,0 +1,300 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestHtmlEngine(t *testing.T) {
+	dir := t.TempDir()
+	view := path.Join(dir, "index.html")
+	layout := path.Join(dir, "layout.html")
+	ioutil.WriteFile(view, []byte(`<p title="{{.title}}">{{.name}}</p>{{.menu}}`), 0600)
+	ioutil.WriteFile(layout, []byte(`<body>{{template "content" .}}</body>`), 0600)
+
+	context := []interface{}{
+		map[string]interface{}{"name": "<script>", "menu": template.HTML("<nav></nav>")},
+		struct{ title string }{"hidden"},
+		map[string]string{"title": `"quoted"`, "name": "ignored"},
+	}
+
+	engine := NewHtmlEngine()
+	html, err := engine.RenderFile(view, context...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<p title="&#34;quoted&#34;">&lt;script&gt;</p><nav></nav>`
+	if html != expected {
+		t.Errorf("expected %s, got %s", expected, html)
+	}
+
+	html, err = engine.RenderFileInLayout(view, layout, context...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != "<body>"+expected+"</body>" {
+		t.Errorf("expected the view in the layout, got %s", html)
+	}
+
+	if _, err = engine.Render("{{.name"); err == nil {
+		t.Error("expected the parsing error")
+	}
+}
+
+type viewUser struct {
+	Name string
+}
+
+type viewMember struct {
+	viewUser
+	Title string
+}
+
+func (this viewMember) FullName() string {
+	return this.Title + " " + this.Name
+}
+
+func TestHtmlEngineStructContext(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+
+	dir := t.TempDir()
+	view := path.Join(dir, "member.html")
+	layout := path.Join(dir, "layout.html")
+	ioutil.WriteFile(view, []byte(`{{.FullName}}|{{.Name}}|{{csrf.Param}}`), 0600)
+	ioutil.WriteFile(layout, []byte(`{{head}}<body>{{template "content" .}}</body>`), 0600)
+
+	controller := &WebController{Context: &Context{}, View: NewView("", "", "")}
+	controller.View.AddHeaderCss(NewCssAsset("/css/app.css").Option("media", "print"))
+	member := viewMember{viewUser{"cheetah"}, "Dr."}
+
+	engine := NewHtmlEngine()
+	for i := 0; i < 2; i++ {
+		html, err := engine.RenderFileInLayout(view, layout, member, controller.getViewScope())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `<link rel="stylesheet" type="text/css" href="/css/app.css" media="print"/><body>Dr. cheetah|cheetah|` + App.Config.csrfFormParam + `</body>`
+		if html = controller.View.replaceBlocks(html); html != expected {
+			t.Errorf("The struct's method and promoted field should be rendered.\nthe wrong result: %s", html)
+		}
+	}
+
+	// The scope's functions return nil if the view is rendered without the scope.
+	html, err := engine.Render(`{{head}}{{.FullName}}`, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Dr. cheetah"; html != expected {
+		t.Errorf("The view should be rendered without the scope.\nthe wrong result: %s", html)
+	}
+}
+
+func TestViewReload(t *testing.T) {
+	view := path.Join(t.TempDir(), "index.html")
+	ioutil.WriteFile(view, []byte("v1"), 0600)
+
+	cached := NewHtmlEngine()
+	cached.SetReload(false)
+	reloaded := NewHtmlEngine()
+	reloaded.SetReload(true)
+	for _, engine := range []*HtmlEngine{cached, reloaded} {
+		if html, _ := engine.RenderFile(view); html != "v1" {
+			t.Fatalf("expected v1, got %s", html)
+		}
+	}
+
+	ioutil.WriteFile(view, []byte("v2"), 0600)
+	modified := time.Now().Add(time.Second)
+	os.Chtimes(view, modified, modified)
+
+	if html, _ := cached.RenderFile(view); html != "v1" {
+		t.Errorf("expected the cached template, got %s", html)
+	}
+	if html, _ := reloaded.RenderFile(view); html != "v2" {
+		t.Errorf("expected the reloaded template, got %s", html)
+	}
+
+	ioutil.WriteFile(view, []byte("{{.broken"), 0600)
+	if err := NewHtmlEngine().Precompile(view); err == nil {
+		t.Error("expected the precompiling error")
+	}
+}
+
+func TestViewFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/index/index.html":       {Data: []byte(`default {{.name}}`)},
+		"views/index/about.html":       {Data: []byte(`about`)},
+		"views/layouts/layout.html":    {Data: []byte(`<body>{{template "content" .}}</body>`)},
+		"themes/dark/index/index.html": {Data: []byte(`dark {{.name}}`)},
+	}
+
+	engine := NewHtmlEngine()
+	engine.SetFS(NewViewFS(fsys, "themes/dark", "views"))
+
+	cases := map[string]string{
+		"index/index.html": "<body>dark cheetah</body>",
+		"index/about.html": "<body>about</body>",
+	}
+	for file, expected := range cases {
+		html, err := engine.RenderFileInLayout(file, "layouts/layout.html", map[string]string{"name": "cheetah"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if html != expected {
+			t.Errorf("%s: expected %s, got %s", file, expected, html)
+		}
+	}
+
+	if _, err := engine.RenderFile("index/missing.html"); err == nil {
+		t.Error("expected the error of the missing view")
+	}
+}
+
+func TestTheme(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	SetViewFS(fstest.MapFS{
+		"views/index/index.html":    {Data: []byte(`default {{.name}}`)},
+		"views/index/about.html":    {Data: []byte(`about`)},
+		"views/layouts/layout.html": {Data: []byte(`<body>{{template "content" .}}</body>`)},
+	})
+	RegisterTheme(NewTheme("dark", fstest.MapFS{
+		"views/index/index.html":    {Data: []byte(`dark {{.name}}`)},
+		"views/layouts/layout.html": {Data: []byte(`<body class="dark">{{template "content" .}}</body>`)},
+		"resources/css/app.css":     {Data: []byte(`body{color:#fff}`)},
+	}))
+
+	engine := NewHtmlEngine()
+	controller := &WebController{ViewEngine: engine, viewEngine: engine}
+	controller.SetTheme("dark")
+	if controller.ViewEngine == engine {
+		t.Fatal("expected the themed view engine")
+	}
+
+	cases := map[string]string{
+		"index/index.html": `<body class="dark">dark cheetah</body>`,
+		"index/about.html": `<body class="dark">about</body>`,
+	}
+	for file, expected := range cases {
+		html, err := controller.ViewEngine.RenderFileInLayout(file, "layouts/layout.html", map[string]string{"name": "cheetah"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if html != expected {
+			t.Errorf("%s: expected %s, got %s", file, expected, html)
+		}
+	}
+
+	// The themed view engine is reused, and the original views are rendered without theme.
+	themed := controller.ViewEngine
+	if controller.SetTheme("dark"); controller.ViewEngine != themed {
+		t.Error("expected the themed view engine to be reused")
+	}
+	if html, _ := engine.RenderFile("index/index.html", map[string]string{"name": "cheetah"}); html != "default cheetah" {
+		t.Errorf("expected the original view, got %s", html)
+	}
+	if controller.SetTheme(""); controller.ViewEngine != engine {
+		t.Error("expected the original view engine")
+	}
+	if err := controller.SetTheme("missing"); (err == nil) || (controller.Theme != nil) {
+		t.Errorf("The error of the unregistered theme should be returned, and the theme should not be changed.\nthe wrong result: %v", err)
+	}
+
+	resources := App.themes["dark"].getResourceFS(fstest.MapFS{"js/app.js": {Data: []byte(`init();`)}})
+	for _, name := range []string{"css/app.css", "js/app.js"} {
+		if !isViewFile(resources, name) {
+			t.Errorf("expected the resource %s", name)
+		}
+	}
+
+	// The themed bundle's files are hashed through the theme's resources.
+	fsys := fstest.MapFS{"css/app.css": {Data: []byte(`body{}`)}}
+	bundle := NewAssetBundle("themed", "/resources", fsys).AddCss("css/app.css")
+	bundle.Themed = true
+	RegisterAssetBundle(bundle)
+	RegisterAssetBundle(NewAssetBundle("plain", "/resources", fsys).AddCss("css/app.css"))
+	cases = map[string]string{
+		"themed": newAssetHash([]byte(`body{color:#fff}`)).integrity,
+		"plain":  newAssetHash([]byte(`body{}`)).integrity,
+	}
+	for name, integrity := range cases {
+		view := NewView("", "", "").RegisterAssetBundle(name)
+		view.theme = App.themes["dark"]
+		if head := view.Head(); !strings.Contains(head, `integrity="`+integrity+`"`) {
+			t.Errorf("The integrity of the %s bundle should be %s.\nthe wrong result: %s", name, integrity, head)
+		}
+	}
+}
+
+func TestPrecompileViews(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	SetViewFS(fstest.MapFS{
+		"views/index/index.html":    {Data: []byte(`index`)},
+		"views/layouts/layout.html": {Data: []byte(`<body>{{template "content" .}}</body>`)},
+	})
+	engine := App.getViewEngine()
+	if engine != App.getViewEngine() {
+		t.Errorf("The view engine which was created on demand should be kept.")
+	}
+
+	htmlEngine := NewHtmlEngine()
+	App.hosts = Hosts{"": &Host{routes: Routes{"/index": &RouteInfo{ControllerInfo: &ControllerInfo{
+		ViewPath:   "index",
+		Layout:     "layout.html",
+		ViewEngine: htmlEngine,
+	}}}}}
+	if err := App.precompileViews(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"index/index.html", "layouts/layout.html", "layouts/layout.html\x00index/index.html"} {
+		if _, ok := htmlEngine.cache.items[key]; !ok {
+			t.Errorf("The template %q should be precompiled.", key)
+		}
+	}
+}
+
+func TestMustachePartials(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index/index.html":        {Data: []byte(`{{> ../partials/header}}<p>{{name}}</p>`)},
+		"partials/header.html":    {Data: []byte(`<h1>{{title}}</h1>{{>nav.html}}`)},
+		"partials/nav.html":       {Data: []byte(`<nav></nav>`)},
+		"partials/recursive.html": {Data: []byte(`{{> recursive}}`)},
+	}
+	expected := `<h1>{{title}}</h1><nav></nav><p>{{name}}</p>`
+	if data, err := inlineMustachePartials(fsys, "index", string(fsys["index/index.html"].Data), 0); (err != nil) || (data != expected) {
+		t.Errorf("The partials should be inlined as %s.\nthe wrong result: %s %v", expected, data, err)
+	}
+	if _, err := inlineMustachePartials(fsys, "partials", `{{> recursive}}`, 0); err == nil {
+		t.Errorf("The recursive partials should be refused.")
+	}
+	if _, err := inlineMustachePartials(fsys, "index", `{{> missing}}`, 0); err == nil {
+		t.Errorf("The error of the missing partial should be returned.")
+	}
+
+	// The partials are read by the OS paths if the view file system is disabled.
+	dir := t.TempDir()
+	ioutil.WriteFile(path.Join(dir, "header.html"), []byte(`<h1></h1>`), 0600)
+	if data, err := inlineMustachePartials(nil, dir, `{{> header}}`, 0); (err != nil) || (data != `<h1></h1>`) {
+		t.Errorf("The partial should be read by the OS path.\nthe wrong result: %s %v", data, err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// The file system which searches the file in the directories in order,
+// the former directory takes precedence, so that the views can be overridden, such as theming.
+type viewSearchFS struct {
+	fsys fs.FS
+	dirs []string
+}
+
+// Create a file system which searches the views in the directories of fsys in order, such as embed.FS.
+func NewViewFS(fsys fs.FS, dirs ...string) fs.FS {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	return &viewSearchFS{
+		fsys: fsys,
+		dirs: dirs,
+	}
+}
+
+func (this *viewSearchFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, dir := range this.dirs {
+		file, err := this.fsys.Open(path.Join(dir, name))
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// The file system which overlays the file systems in order, the former takes precedence.
+type overlayFS []fs.FS
+
+func (this overlayFS) Open(name string) (fs.File, error) {
+	for _, fsys := range this {
+		file, err := fsys.Open(name)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Returns a boolean indicating whether the views are resolved from the view file system,
+// otherwise they are resolved from the controller's package directory under GOPATH.
+func (this *Application) isViewFSEnabled() bool {
+	return (this.viewFS != nil) || (len(this.Config.viewSearchPath) > 0)
+}
+
+// Get the file system of the views.
+// If the view file system was set by SetViewFS, the views are searched in the search path of it,
+// the search path is the view's directory default. If only the search path is configured,
+// the views are searched in the search path relative to the application's base path.
+// Nil will be returned if the view file system is disabled, the views are read from the operating system's
+// file system by the OS paths, they are not the valid names of fs.FS.
+func (this *Application) getViewFS() fs.FS {
+	if !this.isViewFSEnabled() {
+		return nil
+	}
+
+	fsys := this.viewFS
+	if fsys == nil {
+		fsys = os.DirFS(this.basePath)
+	}
+	dirs := this.Config.viewSearchPath
+	if len(dirs) == 0 {
+		dirs = []string{this.Config.viewDir}
+	}
+	return NewViewFS(fsys, dirs...)
+}
+
+// Get the root directory of the application's views, such as the layouts and error views.
+func (this *Application) getViewRoot() string {
+	if this.isViewFSEnabled() {
+		return "."
+	}
+	return path.Join(this.basePath, this.Config.viewDir)
+}
+
+// Returns a boolean indicating whether the view file exists.
+func isViewFile(fsys fs.FS, name string) bool {
+	info, err := statViewFile(fsys, name)
+	return (err == nil) && !info.IsDir()
+}
+
+// Read the view file, it is read by the OS path if fsys is nil.
+func readViewFile(fsys fs.FS, name string) (string, error) {
+	var data []byte
+	var err error
+	if fsys == nil {
+		data, err = os.ReadFile(name)
+	} else {
+		data, err = fs.ReadFile(fsys, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Get the information of the view file, it is read by the OS path if fsys is nil.
+func statViewFile(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// Read the directory of the views, it is read by the OS path if fsys is nil.
+func readViewDir(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	if fsys == nil {
+		return os.ReadDir(name)
+	}
+	return fs.ReadDir(fsys, name)
+}
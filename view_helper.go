@@ -0,0 +1,272 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// View helper, it returns the value which is exposed to the views rendered by the controller,
+// such as the CSRF field. The value should be lazy if it is expensive, such as the methods of a struct,
+// the zero-argument methods are invoked on access by both of the view engines.
+type ViewHelper func(controller *WebController) interface{}
+
+// Register the view helper, it is exposed to the views rendered by the controller as the function named name
+// with HtmlEngine, such as {{csrf.Field}}, and as the variable named name with mustache, the action's context
+// takes precedence with mustache. It should be invoked before running the application.
+func RegisterViewHelper(name string, helper ViewHelper) {
+	App.viewHelpers[name] = helper
+}
+
+// Register the view function, such as {{urlFor "/user/view" "id" 1}} with HtmlEngine.
+// The mustache calls the function by the section whose tag has the arguments, such as
+// {{#urlFor "/user/view" "id" id}}{{/urlFor}}, the quoted strings and numbers are the literals, the others are
+// the variables, and the section's content is rendered and passed as the last argument if it is not empty.
+// The arguments of mustache are converted to the types of the function's params, the result is escaped unless
+// it is template.HTML. It should be invoked before rendering the views.
+func RegisterViewFunc(name string, fn interface{}) {
+	App.viewFuncs[name] = fn
+}
+
+// Register the translated messages of the language, they are used by the view function t.
+func RegisterMessages(language string, messages map[string]string) {
+	if _, ok := App.messages[language]; !ok {
+		App.messages[language] = make(map[string]string)
+	}
+	for message, translation := range messages {
+		App.messages[language][message] = translation
+	}
+}
+
+// Translate the message into the application's language, and format it with the args by fmt.Sprintf.
+// The message is returned as it is if the translation does not exist.
+func Translate(message string, args ...interface{}) string {
+	return TranslateLanguage(App.language, message, args...)
+}
+
+// Translate the message into the language, and format it with the args by fmt.Sprintf.
+func TranslateLanguage(language string, message string, args ...interface{}) string {
+	if translation, ok := App.messages[language][message]; ok {
+		message = translation
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Translate the message into the request's language, it is the view function t of the views rendered by the controller.
+func (this *WebController) Translate(message string, args ...interface{}) string {
+	return TranslateLanguage(this.Language, message, args...)
+}
+
+// Get the request's language, it is the first language of the Accept-Language header which has the registered
+// messages, such as "zh-CN" matches "zh-CN" and then "zh", the application's language is returned otherwise.
+func getRequestLanguage(r *http.Request) string {
+	for _, value := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		language := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		for len(language) > 0 {
+			if _, ok := App.messages[language]; ok {
+				return language
+			}
+			i := strings.LastIndexByte(language, '-')
+			if i < 0 {
+				break
+			}
+			language = language[:i]
+		}
+	}
+	return App.language
+}
+
+func defaultViewHelpers() map[string]ViewHelper {
+	return map[string]ViewHelper{
+		"csrf": func(controller *WebController) interface{} {
+			return &csrfViewHelper{context: controller.Context}
+		},
+		"widgets": func(controller *WebController) interface{} {
+			return &widgetViewHelper{controller: controller}
+		},
+	}
+}
+
+func defaultViewFuncs() template.FuncMap {
+	return template.FuncMap{
+		"urlFor":    UrlFor,
+		"asset":     AssetUrl,
+		"t":         Translate,
+		"date":      FormatDate,
+		"number":    FormatNumber,
+		"pluralize": Pluralize,
+	}
+}
+
+// The CSRF helper, the token is generated on access, so that the session is not started by the views which
+// do not use it, such as {{#csrf}}{{{Field}}}{{/csrf}} with mustache, and {{csrf.Field}} with HtmlEngine.
+type csrfViewHelper struct {
+	context *Context
+}
+
+func (this *csrfViewHelper) Param() string {
+	return App.Config.csrfFormParam
+}
+
+func (this *csrfViewHelper) Token() string {
+	return this.context.CsrfToken()
+}
+
+// The hidden input of the CSRF token.
+func (this *csrfViewHelper) Field() template.HTML {
+	return template.HTML("<input type=\"hidden\" name=\"" + html.EscapeString(this.Param()) + "\" value=\"" + html.EscapeString(this.Token()) + "\"/>")
+}
+
+// The meta tags of the CSRF param and token, they are used by the AJAX requests.
+func (this *csrfViewHelper) Meta() template.HTML {
+	return template.HTML("<meta name=\"csrf-param\" content=\"" + html.EscapeString(this.Param()) + "\"/>\n" +
+		"<meta name=\"csrf-token\" content=\"" + html.EscapeString(this.Token()) + "\"/>")
+}
+
+// Build the URL of the route which is registered in the default host, see also WebController.UrlFor.
+func UrlFor(route string, pairs ...interface{}) (string, error) {
+	return (&WebController{}).UrlFor(route, pairs...)
+}
+
+// Build the URL of the route with the params which are given as key-value pairs.
+// The params named a, b, c and so on are the action's params, such as UrlFor("/user/view", "a", 1, "tab", "posts")
+// returns "/user/view/1?tab=posts", and the others are the query params.
+// The route is looked up in the request's host first, and then the other hosts, the URL of the other host's route
+// is prefixed with the host, such as "//admin.example.com/user/view". The error is returned if the route is not registered
+// or the number of the pairs' values is odd.
+func (this *WebController) UrlFor(route string, pairs ...interface{}) (string, error) {
+	host, _, port := this.getRequestHost()
+	return buildUrl(host, port, route, pairs)
+}
+
+func buildUrl(host *Host, port string, route string, pairs []interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("The params of the route " + route + " should be key-value pairs.")
+	}
+	params := make(map[string]string)
+	query := url.Values{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, value := fmt.Sprint(pairs[i]), fmt.Sprint(pairs[i+1])
+		if (len(key) == 1) && ('a' <= key[0]) && (key[0] <= 'z') {
+			params[key] = value
+		} else {
+			query.Add(key, value)
+		}
+	}
+
+	// The action's params are the route's segments, such as "/user/view/:a".
+	pattern := route
+	for k := 0; k < len(params); k++ {
+		pattern += "/:" + string(rune('a'+k))
+	}
+	prefix := ""
+	if !isRouteRegistered(host, pattern) {
+		names := make([]string, 0, len(App.hosts))
+		for name := range App.hosts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		found := false
+		for _, name := range names {
+			if (App.hosts[name] != host) && isRouteRegistered(App.hosts[name], pattern) {
+				if len(name) > 0 {
+					prefix = "//" + name + port
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", errors.New("The route is not registered: " + pattern)
+		}
+	}
+
+	u := prefix + route
+	for k := 0; k < len(params); k++ {
+		value, ok := params[string(rune('a'+k))]
+		if !ok {
+			return "", errors.New("The param " + string(rune('a'+k)) + " of the route is missing: " + route)
+		}
+		u += "/" + url.PathEscape(value)
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+func isRouteRegistered(host *Host, route string) bool {
+	if host == nil {
+		return false
+	}
+	if route == "/" {
+		return true
+	}
+	_, ok := host.routes[route]
+	return ok
+}
+
+// Get the fingerprinted URL of the file of the registered asset bundle.
+// The error is returned if the bundle is not registered, so that the view reports it instead of panicking.
+func AssetUrl(bundle, file string) (string, error) {
+	assetBundle, ok := App.assetBundles[bundle]
+	if !ok {
+		return "", errors.New("The asset bundle is not registered: " + bundle)
+	}
+	url, _ := assetBundle.url(file, nil)
+	return url, nil
+}
+
+// Get the fingerprinted URL of the file of the registered asset bundle, the file of the request's theme is
+// fingerprinted if the bundle is themed. It is the view function asset of the views rendered by the controller.
+func (this *WebController) AssetUrl(bundle, file string) (string, error) {
+	assetBundle, ok := App.assetBundles[bundle]
+	if !ok {
+		return "", errors.New("The asset bundle is not registered: " + bundle)
+	}
+	url, _ := assetBundle.url(file, this.Theme)
+	return url, nil
+}
+
+// Format the time by the layout, such as "2006-01-02".
+func FormatDate(t time.Time, layout string) string {
+	return t.Format(layout)
+}
+
+// Format the number with the decimals and the thousands separators, such as FormatNumber(1234.5, 2) returns "1,234.50".
+func FormatNumber(number float64, decimals int) string {
+	s := strconv.FormatFloat(number, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i:]
+	}
+	for i := len(integer) - 3; i > 0; i -= 3 {
+		integer = integer[:i] + "," + integer[i:]
+	}
+	return sign + integer + fraction
+}
+
+// Returns the count and the singular or plural word, such as Pluralize(2, "item", "items") returns "2 items".
+func Pluralize(count int, singular, plural string) string {
+	if count == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(count) + " " + plural
+}
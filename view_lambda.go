@@ -0,0 +1,384 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/HeadwindFly/cheetah/utils/string"
+	"github.com/hoisie/mustache"
+	"html"
+	"html/template"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The parsed mustache template, the lambdas are compiled into the markers when parsing, such as
+// {{#urlFor "/user/view" "id" id}}{{/urlFor}}, and the markers are replaced by the results of the view functions
+// after rendering, the inner lambdas are called first.
+type mustacheTemplate struct {
+	template *mustache.Template
+	id       string
+	lambdas  []*mustacheLambda
+	names    map[string]bool // the names referred by the tags.
+}
+
+type mustacheLambda struct {
+	name  string
+	args  []mustacheArg
+	block *mustacheTemplate // the content of the block function's section, it is rendered on demand.
+}
+
+type mustacheArg struct {
+	value    string // the literal, or the variable's name.
+	variable bool
+}
+
+// The token of the markers, it is generated randomly and never output,
+// so that the markers can not be forged by the rendered values.
+var mustacheLambdaToken = hex.EncodeToString(stringutil.GenerateRandomByte(16))
+
+// The markers of the lambdas, they are the characters of the private use area followed by the token.
+// The lambda is compiled into start, id, args, the arguments separated by separator, content, the content and end.
+var (
+	mustacheLambdaStart     = "\uE000" + mustacheLambdaToken
+	mustacheLambdaArgs      = "\uE001" + mustacheLambdaToken
+	mustacheLambdaSeparator = "\uE002" + mustacheLambdaToken
+	mustacheLambdaContent   = "\uE003" + mustacheLambdaToken
+	mustacheLambdaEnd       = "\uE004" + mustacheLambdaToken
+)
+
+// The sequence of the templates' IDs, the view and the layout are distinguished by it.
+var mustacheTemplateId uint64
+
+var (
+	mustacheTagRegexp  = regexp.MustCompile(`\{\{([#^/])\s*([^\s}]+)((?:\s+[^}]*?)?)\s*\}\}`)
+	mustacheArgRegexp  = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|\S+`)
+	mustacheNameRegexp = regexp.MustCompile(`\{\{[#^&{]?\s*([^\s{}!>/.]+)`)
+)
+
+func parseMustache(data string) (*mustacheTemplate, error) {
+	this := &mustacheTemplate{
+		id:    strconv.FormatUint(atomic.AddUint64(&mustacheTemplateId, 1), 10),
+		names: make(map[string]bool),
+	}
+	for _, m := range mustacheNameRegexp.FindAllStringSubmatch(data, -1) {
+		this.names[m[1]] = true
+	}
+	data, err := this.compileLambdas(data)
+	if err != nil {
+		return nil, err
+	}
+	if this.template, err = mustache.ParseString(data); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Returns a boolean indicating whether the template refers to the name.
+func (this *mustacheTemplate) uses(name string) bool {
+	return this.names[name]
+}
+
+// Compile the sections whose tags have the arguments into the markers.
+func (this *mustacheTemplate) compileLambdas(data string) (string, error) {
+	// Whether the opened sections are the lambdas.
+	sections := make([]bool, 0)
+	var buf strings.Builder
+	last := 0
+	matches := mustacheTagRegexp.FindAllStringSubmatchIndex(data, -1)
+	for k := 0; k < len(matches); k++ {
+		m := matches[k]
+		kind, name, args := data[m[2]:m[3]], data[m[4]:m[5]], strings.TrimSpace(data[m[6]:m[7]])
+		switch {
+		case (kind == "#") && (len(args) > 0):
+			lambda := &mustacheLambda{name: name}
+			for _, arg := range mustacheArgRegexp.FindAllString(args, -1) {
+				if arg[0] == '"' {
+					value, err := strconv.Unquote(arg)
+					if err != nil {
+						return "", errors.New("The argument of the lambda is invalid: " + arg)
+					}
+					lambda.args = append(lambda.args, mustacheArg{value: value})
+				} else if _, err := strconv.ParseFloat(arg, 64); err == nil {
+					lambda.args = append(lambda.args, mustacheArg{value: arg})
+				} else {
+					lambda.args = append(lambda.args, mustacheArg{value: arg, variable: true})
+				}
+			}
+
+			buf.WriteString(data[last:m[0]])
+			buf.WriteString(mustacheLambdaStart + this.id + ":" + strconv.Itoa(len(this.lambdas)) + mustacheLambdaArgs)
+			for i, arg := range lambda.args {
+				if i > 0 {
+					buf.WriteString(mustacheLambdaSeparator)
+				}
+				// The variable is escaped like the other values, and unescaped when the function is called.
+				if arg.variable {
+					buf.WriteString("{{" + arg.value + "}}")
+				}
+			}
+			buf.WriteString(mustacheLambdaContent)
+			last = m[1]
+			this.lambdas = append(this.lambdas, lambda)
+
+			if !viewBlockNames[name] {
+				sections = append(sections, true)
+				continue
+			}
+			// The content of the block function's section is compiled separately, and the section is closed.
+			end := findMustacheSectionEnd(data, matches, k)
+			if end < 0 {
+				return "", errors.New("The section is not closed: " + name)
+			}
+			block, err := parseMustache(data[m[1]:matches[end][0]])
+			if err != nil {
+				return "", err
+			}
+			lambda.block = block
+			buf.WriteString(mustacheLambdaEnd)
+			last = matches[end][1]
+			k = end
+		case kind == "/":
+			if len(sections) == 0 {
+				continue
+			}
+			if sections[len(sections)-1] {
+				buf.WriteString(data[last:m[0]])
+				buf.WriteString(mustacheLambdaEnd)
+				last = m[1]
+			}
+			sections = sections[:len(sections)-1]
+		default:
+			sections = append(sections, false)
+		}
+	}
+	buf.WriteString(data[last:])
+	return buf.String(), nil
+}
+
+// Find the tag which closes the section opened by the k-th tag, -1 will be returned if it is not closed.
+func findMustacheSectionEnd(data string, matches [][]int, k int) int {
+	depth := 0
+	for i := k + 1; i < len(matches); i++ {
+		m := matches[i]
+		if data[m[2]:m[3]] != "/" {
+			depth++
+			continue
+		}
+		if depth == 0 {
+			return i
+		}
+		depth--
+	}
+	return -1
+}
+
+// Render the template, and replace the markers of the lambdas by the results of the functions.
+func (this *mustacheTemplate) render(layout *mustacheTemplate, funcs template.FuncMap, context []interface{}) (string, error) {
+	templates := map[string]*mustacheTemplate{this.id: this}
+	var output string
+	if layout != nil {
+		templates[layout.id] = layout
+		output = this.template.RenderInLayout(layout.template, context...)
+	} else {
+		output = this.template.Render(context...)
+	}
+
+	for {
+		start := strings.LastIndex(output, mustacheLambdaStart)
+		if start < 0 {
+			return output, nil
+		}
+		end := strings.Index(output[start:], mustacheLambdaEnd)
+		if end < 0 {
+			return "", errors.New("The lambda of the view is not closed.")
+		}
+		end += start
+
+		marker := output[start+len(mustacheLambdaStart) : end]
+		i := strings.Index(marker, mustacheLambdaArgs)
+		j := strings.Index(marker, mustacheLambdaContent)
+		if (i < 0) || (j < i) {
+			return "", errors.New("The lambda of the view is invalid.")
+		}
+		lambda := getMustacheLambda(templates, marker[:i])
+		if lambda == nil {
+			return "", errors.New("The lambda of the view is not found.")
+		}
+		values := strings.Split(marker[i+len(mustacheLambdaArgs):j], mustacheLambdaSeparator)
+		result, err := lambda.call(funcs, context, values, marker[j+len(mustacheLambdaContent):])
+		if err != nil {
+			return "", err
+		}
+		output = output[:start] + result + output[end+len(mustacheLambdaEnd):]
+	}
+}
+
+func getMustacheLambda(templates map[string]*mustacheTemplate, id string) *mustacheLambda {
+	i := strings.IndexByte(id, ':')
+	if i < 0 {
+		return nil
+	}
+	tmpl, ok := templates[id[:i]]
+	if !ok {
+		return nil
+	}
+	index, err := strconv.Atoi(id[i+1:])
+	if (err != nil) || (index < 0) || (index >= len(tmpl.lambdas)) {
+		return nil
+	}
+	return tmpl.lambdas[index]
+}
+
+// Call the function with the arguments, the values are the rendered and escaped variables,
+// and the rendered content is the last argument if it is not empty.
+// The block function renders the content of the section with the context on demand.
+func (this *mustacheLambda) call(funcs template.FuncMap, context []interface{}, values []string, content string) (string, error) {
+	fn, ok := funcs[this.name]
+	if !ok {
+		return "", errors.New("The view function is not registered: " + this.name)
+	}
+	args := make([]string, 0, len(this.args)+1)
+	for i, arg := range this.args {
+		if arg.variable && (i < len(values)) {
+			args = append(args, html.UnescapeString(values[i]))
+		} else {
+			args = append(args, arg.value)
+		}
+	}
+	if block, ok := fn.(viewBlock); ok && (this.block != nil) {
+		if len(args) < 2 {
+			return "", errors.New("The key and ttl of the view function " + this.name + " are required.")
+		}
+		html, err := block(args[0], args[1], func() (string, error) {
+			return this.block.render(nil, funcs, context)
+		}, args[2:]...)
+		if err != nil {
+			return "", errors.New("Error calling the view function " + this.name + ": " + err.Error())
+		}
+		return string(html), nil
+	}
+	if len(content) > 0 {
+		args = append(args, content)
+	}
+
+	result, err := callViewFunc(fn, args)
+	if err != nil {
+		return "", errors.New("Error calling the view function " + this.name + ": " + err.Error())
+	}
+	return result, nil
+}
+
+// Call the view function with the arguments which are converted to the types of the params,
+// the result is escaped unless it is template.HTML.
+func callViewFunc(fn interface{}, args []string) (string, error) {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return "", errors.New("it is not a function")
+	}
+	n := t.NumIn()
+	if (t.IsVariadic() && (len(args) < n-1)) || (!t.IsVariadic() && (len(args) != n)) {
+		return "", fmt.Errorf("wrong number of the arguments, want %d got %d", n, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var typ reflect.Type
+		if t.IsVariadic() && (i >= n-1) {
+			typ = t.In(n - 1).Elem()
+		} else {
+			typ = t.In(i)
+		}
+		value, err := convertViewArg(arg, typ)
+		if err != nil {
+			return "", err
+		}
+		in[i] = value
+	}
+
+	out := v.Call(in)
+	if len(out) == 0 {
+		return "", nil
+	}
+	if len(out) > 1 {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return "", err
+		}
+	}
+	switch result := out[0].Interface().(type) {
+	case nil:
+		return "", nil
+	case template.HTML:
+		return string(result), nil
+	case string:
+		return html.EscapeString(result), nil
+	default:
+		return html.EscapeString(fmt.Sprint(result)), nil
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// The layouts of the rendered times, the first one is the layout of time.Time's String method.
+var viewTimeLayouts = []string{"2006-01-02 15:04:05.999999999 -0700 MST", time.RFC3339Nano, "2006-01-02"}
+
+func convertViewArg(arg string, typ reflect.Type) (reflect.Value, error) {
+	if typ == timeType {
+		// Strip the monotonic clock reading.
+		if i := strings.Index(arg, " m="); i >= 0 {
+			arg = arg[:i]
+		}
+		for _, layout := range viewTimeLayouts {
+			if t, err := time.Parse(layout, arg); err == nil {
+				return reflect.ValueOf(t), nil
+			}
+		}
+		return reflect.Value{}, errors.New("the argument is not a time: " + arg)
+	}
+
+	value := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		value.SetString(arg)
+	case reflect.Interface:
+		if !reflect.TypeOf(arg).AssignableTo(typ) {
+			return reflect.Value{}, errors.New("the argument can not be converted to " + typ.String())
+		}
+		value.Set(reflect.ValueOf(arg))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(arg, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(arg, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(arg, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(arg)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value.SetBool(b)
+	default:
+		return reflect.Value{}, errors.New("the argument can not be converted to " + typ.String())
+	}
+	return value, nil
+}
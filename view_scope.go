@@ -0,0 +1,170 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"bytes"
+	"html/template"
+	"reflect"
+)
+
+// The request-scoped values and functions of the views, such as the head and footer blocks, the flash messages,
+// the cached fragments and the view helpers. The controller appends it to the context of the view engines,
+// so that the action's context is still the root value of the views.
+// The HtmlEngine exposes the entries as the functions, such as {{head}} and {{csrf.Field}},
+// the mustache looks up the values after the action's context, such as {{{head}}}, and calls the functions
+// by the lambdas, such as {{#urlFor "/user/view" "a" id}}{{/urlFor}}.
+type viewScope map[string]interface{}
+
+// The names of the scope's entries besides the view helpers, the HtmlEngine declares them when parsing the views.
+var viewScopeNames = []string{"head", "footer", "flashes", "fragment", "cache", "widget"}
+
+// The value of the scope which is loaded when the view uses it, such as the flash messages
+// which are cleared after being read. The HtmlEngine calls it as a function,
+// and the mustache loads it only if the view or layout refers to it.
+type viewLazyValue func() interface{}
+
+// The block function of the views, the block is rendered by fn on demand, such as the cache block.
+// The HtmlEngine renders the block by the template whose name and data follow the key and ttl,
+// and the mustache renders the content of the section.
+type viewBlock func(key string, ttl string, fn func() (string, error), tags ...string) (template.HTML, error)
+
+// The names of the scope's block functions, the mustache compiles the content of their sections separately.
+var viewBlockNames = map[string]bool{"cache": true}
+
+// Set the entries of the values, the existing entries will be replaced.
+func (this viewScope) set(values map[string]interface{}) {
+	for name, value := range values {
+		this[name] = value
+	}
+}
+
+// Get the functions of the scope, the values are returned by the functions without arguments.
+func (this viewScope) funcs() template.FuncMap {
+	funcs := make(template.FuncMap, len(this))
+	for name, value := range this {
+		if (value != nil) && (reflect.TypeOf(value).Kind() == reflect.Func) {
+			funcs[name] = value
+			continue
+		}
+		v := value
+		funcs[name] = func() interface{} {
+			return v
+		}
+	}
+	return funcs
+}
+
+// Get the functions of the scope for the HtmlEngine, the block functions render the blocks by the templates of tmpl.
+func (this viewScope) htmlFuncs(tmpl *template.Template) template.FuncMap {
+	funcs := this.funcs()
+	for name, fn := range funcs {
+		if block, ok := fn.(viewBlock); ok {
+			funcs[name] = func(key string, ttl string, name string, data interface{}, tags ...string) (template.HTML, error) {
+				return block(key, ttl, func() (string, error) {
+					var buf bytes.Buffer
+					err := tmpl.ExecuteTemplate(&buf, name, data)
+					return buf.String(), err
+				}, tags...)
+			}
+		}
+	}
+	return funcs
+}
+
+// Get the placeholders of the scope's functions, they release the request-scoped values,
+// and the functions which the scope's functions override are restored.
+func (this viewScope) placeholders(funcs template.FuncMap) template.FuncMap {
+	placeholders := make(template.FuncMap, len(this))
+	for name := range this {
+		if fn, ok := funcs[name]; ok {
+			placeholders[name] = fn
+		} else {
+			placeholders[name] = viewScopePlaceholder
+		}
+	}
+	return placeholders
+}
+
+// Get the values of the scope, the functions are excluded,
+// and the lazy values are loaded only if they are used.
+func (this viewScope) values(used func(name string) bool) map[string]interface{} {
+	values := make(map[string]interface{}, len(this))
+	for name, value := range this {
+		if lazy, ok := value.(viewLazyValue); ok {
+			if used(name) {
+				values[name] = lazy()
+			}
+		} else if (value == nil) || (reflect.TypeOf(value).Kind() != reflect.Func) {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// Split the scope from the context, nil will be returned if the context has no scope.
+func splitViewScope(context []interface{}) ([]interface{}, viewScope) {
+	for i, value := range context {
+		if scope, ok := value.(viewScope); ok {
+			rest := make([]interface{}, 0, len(context)-1)
+			rest = append(rest, context[:i]...)
+			return append(rest, context[i+1:]...), scope
+		}
+	}
+	return context, nil
+}
+
+// Get the placeholders of the scope's functions, they are declared when parsing the views,
+// and they return nil if the views are rendered without the scope, such as the error views.
+func getViewScopePlaceholders() template.FuncMap {
+	funcs := make(template.FuncMap, len(viewScopeNames)+len(App.viewHelpers))
+	for _, name := range viewScopeNames {
+		funcs[name] = viewScopePlaceholder
+	}
+	for name := range App.viewHelpers {
+		funcs[name] = viewScopePlaceholder
+	}
+	return funcs
+}
+
+func viewScopePlaceholder(args ...interface{}) interface{} {
+	return nil
+}
+
+// Get the scope of the views rendered by the controller.
+func (this *WebController) getViewScope() viewScope {
+	scope := make(viewScope)
+	// The cached fragments, such as {{{sidebar}}} with mustache and {{fragment "sidebar"}} with HtmlEngine.
+	scope.set(this.getFragmentContext())
+	scope["fragment"] = func(name string) template.HTML {
+		html, _ := this.fragments[name].(template.HTML)
+		return html
+	}
+	// The head and footer blocks.
+	if this.View != nil {
+		scope.set(this.View.context())
+	}
+	// The flash messages are loaded once when the view or layout uses them, so that they are not cleared
+	// by the views which do not render them.
+	var flashes []map[string]string
+	loaded := false
+	scope["flashes"] = viewLazyValue(func() interface{} {
+		if !loaded {
+			loaded = true
+			flashes = this.getFlashList()
+		}
+		return flashes
+	})
+	// The view functions which depend on the request.
+	scope["cache"] = viewBlock(this.CacheBlock)
+	scope["widget"] = (&widgetViewHelper{controller: this}).Render
+	scope["urlFor"] = this.UrlFor
+	scope["t"] = this.Translate
+	scope["asset"] = this.AssetUrl
+	// The view helpers.
+	for name, helper := range App.viewHelpers {
+		scope[name] = helper(this)
+	}
+	return scope
+}
@@ -0,0 +1,435 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAssetOutput(t *testing.T) {
+	cases := []struct {
+		asset    Asset
+		expected string
+	}{
+		{NewCssAsset("/css/app.css"), `<link rel="stylesheet" type="text/css" href="/css/app.css"/>`},
+		{NewCssAsset("/css/ie.css").Condition("lt IE 9"), `<!--[if lt IE 9]> --><link rel="stylesheet" type="text/css" href="/css/ie.css"/><!-- <![endif]-->`},
+		{NewJsAsset("/js/app.js", "").Option("defer", "defer").Option("async", "async"), `<script type="text/javascript" src="/js/app.js" async="async" defer="defer"></script>`},
+		{NewJsAsset("", "init();").Option("id", `"main"`), `<script type="text/javascript" id="&#34;main&#34;">init();</script>`},
+	}
+	for _, c := range cases {
+		if output := c.asset.Output(); output != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, output)
+		}
+	}
+}
+
+func TestViewHeadAndFooter(t *testing.T) {
+	view := NewView("<Home>", "go,web", "")
+	view.SetMeta("author", "cheetah").SetMeta("author", "HeadwindFly")
+	view.AddHeaderCss(NewCssAsset("/css/app.css")).AddHeaderCss(NewCssAsset("/css/app.css"))
+	view.AddHeaderCss(NewCssAsset("/css/print.css").Option("media", "print"))
+	view.AddFooterCss(NewCssAsset("/css/app.css"))
+	view.AddFooterJs(NewJsAsset("/js/app.js", "")).AddFooterJs(NewJsAsset("/js/app.js", ""))
+
+	head := `<title>&lt;Home&gt;</title>
+<meta name="keywords" content="go,web"/>
+<meta name="author" content="HeadwindFly"/>
+<link rel="stylesheet" type="text/css" href="/css/app.css"/>
+<link rel="stylesheet" type="text/css" href="/css/print.css" media="print"/>`
+	if view.Head() != head {
+		t.Errorf("expected head:\n%s\ngot:\n%s", head, view.Head())
+	}
+
+	footer := `<script type="text/javascript" src="/js/app.js"></script>`
+	if view.Footer() != footer {
+		t.Errorf("expected footer:\n%s\ngot:\n%s", footer, view.Footer())
+	}
+}
+
+func TestAssetBundle(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+
+	fsys := fstest.MapFS{
+		"css/app.css": {Data: []byte("body{}")},
+		"js/app.js":   {Data: []byte("init();")},
+	}
+	RegisterAssetBundle(NewAssetBundle("jquery", "/resources", nil).AddJs("//cdn.example.com/jquery.js"))
+	RegisterAssetBundle(NewAssetBundle("app", "/resources/", fsys).AddCss("css/app.css").AddJs("js/app.js").DependsOn("jquery"))
+
+	view := NewView("", "", "")
+	view.RegisterAssetBundle("app", "jquery")
+	view.AddFooterJs(NewJsAsset("/js/page.js", ""))
+
+	hash := newAssetHash([]byte("body{}"))
+	head := `<link rel="stylesheet" type="text/css" href="/resources/css/app.css?v=` + hash.fingerprint + `" integrity="` + hash.integrity + `" crossorigin="anonymous"/>`
+	if view.Head() != head {
+		t.Errorf("expected head:\n%s\ngot:\n%s", head, view.Head())
+	}
+
+	hash = newAssetHash([]byte("init();"))
+	footer := `<script type="text/javascript" src="//cdn.example.com/jquery.js"></script>
+<script type="text/javascript" src="/resources/js/app.js?v=` + hash.fingerprint + `" integrity="` + hash.integrity + `" crossorigin="anonymous"></script>
+<script type="text/javascript" src="/js/page.js"></script>`
+	if view.Footer() != footer {
+		t.Errorf("expected footer:\n%s\ngot:\n%s", footer, view.Footer())
+	}
+}
+
+func TestValidateAssetBundles(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+
+	RegisterAssetBundle(NewAssetBundle("app", "/", nil).DependsOn("jquery"))
+	if err := validateAssetBundles(); err == nil {
+		t.Errorf("The error of the unregistered dependency should be returned.")
+	}
+	RegisterAssetBundle(NewAssetBundle("jquery", "/", nil))
+	if err := validateAssetBundles(); err != nil {
+		t.Errorf("The dependencies should be valid.\nthe wrong result: %v", err)
+	}
+
+	RegisterAssetBundle(NewAssetBundle("a", "/", nil).DependsOn("b"))
+	RegisterAssetBundle(NewAssetBundle("b", "/", nil).DependsOn("a"))
+	if err := validateAssetBundles(); err == nil {
+		t.Errorf("The error of the circular dependencies should be returned.")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("The unregistered asset bundle of the view should panic.")
+		}
+	}()
+	NewView("", "", "").RegisterAssetBundle("missing")
+}
+
+func TestResourceHandle(t *testing.T) {
+	fsys := fstest.MapFS{"css/app.css": {Data: []byte("body{}")}}
+	handle := newResourceHandle(http.FS(fsys))
+	fingerprint := newAssetHash([]byte("body{}")).fingerprint
+
+	cases := []struct {
+		version      string
+		cacheControl string
+	}{
+		{fingerprint, resourceCacheControl},
+		{"outdated", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handle(w, httptest.NewRequest("GET", "/resources/css/app.css?v="+c.version, nil), httprouter.Params{{Key: "filepath", Value: "/css/app.css"}})
+		if w.Code != http.StatusOK {
+			t.Errorf("The resource should be served.\nthe wrong result: %d", w.Code)
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != c.cacheControl {
+			t.Errorf("The Cache-Control of the version %q should be %q.\nthe wrong result: %q", c.version, c.cacheControl, cacheControl)
+		}
+	}
+}
+
+func TestAssetFingerprint(t *testing.T) {
+	data := []byte("body{}")
+	sum := sha256.Sum256(data)
+	if hash := newAssetHash(data); hash.fingerprint != hex.EncodeToString(sum[:])[:12] {
+		t.Errorf("unexpected fingerprint: %s", hash.fingerprint)
+	}
+}
+
+func TestMinifyCss(t *testing.T) {
+	src := `/* reset */
+body , p > a {
+	margin : 0 ;
+	font-family: "Open  Sans";
+}
+@media screen and (max-width: 600px) { a:hover { color: red; } }
+`
+	expected := `body,p>a{margin : 0;font-family: "Open  Sans"}@media screen and (max-width: 600px){a:hover{color: red}}`
+	if css := minifyCss(src); css != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, css)
+	}
+}
+
+func TestMinifyJs(t *testing.T) {
+	src := `// comment
+var url = "http://example.com"; /* inline */ var re = /\/\/[a/]*/g;
+
+function add(a, b) {
+	return a / b // divide
+}
+var s = 'it\'s';`
+	expected := `var url = "http://example.com"; var re = /\/\/[a/]*/g;
+function add(a, b) {
+return a / b
+}
+var s = 'it\'s';`
+	if js := minifyJs(src); js != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, js)
+	}
+}
+
+func TestAssetBundleBuild(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+
+	fsys := fstest.MapFS{
+		"css/a.css": {Data: []byte("a { color: red; }")},
+		"css/b.css": {Data: []byte(`@import 'reset.css'; b { background: url("../img/bg.png") url(/img/root.png) url(data:image/png;base64,AA==); }`)},
+		"js/a.js":   {Data: []byte("var a = 1; // a")},
+		"js/b.js":   {Data: []byte("var b = 2;")},
+	}
+	bundle := NewAssetBundle("app", "/resources", fsys).AddCss("css/a.css", "css/b.css").AddJs("js/a.js", "//cdn.example.com/lib.js", "js/b.js")
+	RegisterAssetBundle(bundle)
+
+	dir := t.TempDir()
+	if err := BuildAssetBundles(dir, "/assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	css := "a{color: red}\n@import '/resources/css/reset.css';b{background: url(\"/resources/img/bg.png\") url(/img/root.png) url(data:image/png;base64,AA==)}"
+	hash := newAssetHash([]byte(css))
+	if data, _ := ioutil.ReadFile(path.Join(dir, "app."+hash.fingerprint+".css")); string(data) != css {
+		t.Errorf("unexpected built CSS: %s", data)
+	}
+
+	// The built files are requested with the fingerprints, and the absolute URLs keep the declared order.
+	view := NewView("", "", "").RegisterAssetBundle("app")
+	head := `<link rel="stylesheet" type="text/css" href="/assets/app.` + hash.fingerprint + `.css?v=` + hash.fingerprint + `" integrity="` + hash.integrity + `" crossorigin="anonymous"/>`
+	if view.Head() != head {
+		t.Errorf("expected head:\n%s\ngot:\n%s", head, view.Head())
+	}
+
+	hashA, hashB := newAssetHash([]byte("var a = 1;")), newAssetHash([]byte("var b = 2;"))
+	footer := `<script type="text/javascript" src="/assets/app.` + hashA.fingerprint + `.js?v=` + hashA.fingerprint + `" integrity="` + hashA.integrity + `" crossorigin="anonymous"></script>
+<script type="text/javascript" src="//cdn.example.com/lib.js"></script>
+<script type="text/javascript" src="/assets/app.` + hashB.fingerprint + `.js?v=` + hashB.fingerprint + `" integrity="` + hashB.integrity + `" crossorigin="anonymous"></script>`
+	if view.Footer() != footer {
+		t.Errorf("expected footer:\n%s\ngot:\n%s", footer, view.Footer())
+	}
+
+	// The built file is cached by the client forever.
+	w := httptest.NewRecorder()
+	newResourceHandle(http.Dir(dir))(w, httptest.NewRequest("GET", "/assets/app."+hashA.fingerprint+".js?v="+hashA.fingerprint, nil),
+		httprouter.Params{{Key: "filepath", Value: "/app." + hashA.fingerprint + ".js"}})
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != resourceCacheControl {
+		t.Errorf("The Cache-Control of the built file should be %q.\nthe wrong result: %q", resourceCacheControl, cacheControl)
+	}
+}
+
+func TestViewHelpers(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	RegisterMessages("en", map[string]string{"Hello %s": "Hi %s"})
+	RegisterMessages("zh", map[string]string{"Hello %s": "你好 %s"})
+	App.defaultHost = &Host{routes: Routes{"/user/index": {}, "/user/view/:a": {}}}
+	App.hosts = Hosts{"www.example.com": App.defaultHost, "admin.example.com": &Host{routes: Routes{"/admin/index": {}}}}
+
+	request := httptest.NewRequest("GET", "http://www.example.com:8080/user/index", nil)
+	request.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	controller := &WebController{
+		Context:  &Context{Request: request, trueCsrfToken: func(generate bool) string { return "secret" }},
+		Language: getRequestLanguage(request),
+	}
+	engine := NewHtmlEngine()
+	html, err := engine.Render(
+		`{{csrf.Field}} {{urlFor "/user/view" "a" 1 "tab" "posts"}} {{urlFor "/admin/index"}} {{t "Hello %s" .name}} {{number 1234567.891 2}} {{pluralize 2 "item" "items"}}`,
+		map[string]interface{}{"name": "cheetah"}, controller.getViewScope(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := `<input type="hidden" name="` + App.Config.csrfFormParam + `" value="`
+	suffix := `"/> /user/view/1?tab=posts //admin.example.com:8080/admin/index 你好 cheetah 1,234,567.89 2 items`
+	if !strings.HasPrefix(html, prefix) || !strings.HasSuffix(html, suffix) {
+		t.Errorf("unexpected output: %s", html)
+	}
+
+	if _, err = controller.UrlFor("/user/missing"); err == nil {
+		t.Errorf("The error of the unregistered route should be returned.")
+	}
+	if _, err = controller.UrlFor("/user/view", "a"); err == nil {
+		t.Errorf("The error of the odd number of the route's params should be returned.")
+	}
+	if language := getRequestLanguage(httptest.NewRequest("GET", "/", nil)); language != App.language {
+		t.Errorf("The request's language should be %s.\nthe wrong result: %s", App.language, language)
+	}
+
+	// The view functions are the lambdas of mustache, the section's content is the last argument.
+	html, err = NewMustacheEngine().Render(
+		`{{#urlFor "/user/view" "a" id}}{{/urlFor}}|{{#t "Hello %s" name}}{{/t}}|{{#t "Hello %s"}}{{#urlFor "/user/index"}}{{/urlFor}}{{/t}}|`+
+			`{{#number price 2}}{{/number}}|{{#pluralize count "item" "items"}}{{/pluralize}}|{{#date created "2006-01-02"}}{{/date}}`,
+		map[string]interface{}{"id": 1, "name": "<cheetah>", "price": 1234.5, "count": 1, "created": time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)},
+		controller.getViewScope(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `/user/view/1|你好 &lt;cheetah&gt;|你好 /user/index|1,234.50|1 item|2016-01-02`
+	if html != expected {
+		t.Errorf("The lambdas' output should be %s.\nthe wrong result: %s", expected, html)
+	}
+	if _, err = NewMustacheEngine().Render(`{{#missing "a"}}{{/missing}}`); err == nil {
+		t.Errorf("The error of the unregistered view function should be returned.")
+	}
+
+	// The rendered values can neither break nor forge the markers of the lambdas.
+	hostile := "hi \uE000 \uE0010:0\uE001\uE003\uE004 \uE002 there"
+	for _, data := range []string{`<p>{{comment}}</p>`, `<p>{{comment}}</p>{{#urlFor "/user/index"}}{{/urlFor}}`} {
+		html, err = NewMustacheEngine().Render(data, map[string]interface{}{"comment": hostile}, controller.getViewScope())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(html, "<p>"+hostile+"</p>") {
+			t.Errorf("The hostile value should be rendered as it is.\nthe wrong result: %q", html)
+		}
+	}
+	html, err = NewMustacheEngine().Render(`{{#t name}}{{/t}}`, map[string]interface{}{"name": "<b>\uE002\uE003</b>"}, controller.getViewScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "&lt;b&gt;\uE002\uE003&lt;/b&gt;"; html != expected {
+		t.Errorf("The variable argument should be passed as it is and the result should be escaped %q.\nthe wrong result: %q", expected, html)
+	}
+
+	generated := false
+	controller = &WebController{Context: &Context{trueCsrfToken: func(generate bool) string {
+		generated = true
+		return "secret"
+	}}}
+	if _, err = engine.Render(`{{.name}}`, map[string]interface{}{"name": "cheetah"}, controller.getViewScope()); err != nil {
+		t.Fatal(err)
+	}
+	if generated {
+		t.Error("the CSRF token should not be generated if it is not used")
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	cases := []struct {
+		number   float64
+		decimals int
+		expected string
+	}{
+		{0, 0, "0"},
+		{999, 0, "999"},
+		{1000, 0, "1,000"},
+		{-1234.5, 2, "-1,234.50"},
+		{123456789, 1, "123,456,789.0"},
+	}
+	for _, c := range cases {
+		if result := FormatNumber(c.number, c.decimals); result != c.expected {
+			t.Errorf("FormatNumber(%v, %d): expected %s, got %s", c.number, c.decimals, c.expected, result)
+		}
+	}
+}
+
+type alertWidget struct {
+	prepares int
+}
+
+func (this *alertWidget) RegisterAssets(view *View) {
+	view.AddHeaderCss(NewCssAsset("/css/alert.css"))
+}
+
+func (this *alertWidget) Prepare(controller *WebController, params map[string]interface{}) (interface{}, error) {
+	this.prepares++
+	return params, nil
+}
+
+func (this *alertWidget) Template() string {
+	return "alert"
+}
+
+func (this *alertWidget) CacheKey(params map[string]interface{}) (string, time.Duration, []CacheDependency) {
+	return fmt.Sprint(params["message"]), time.Minute, nil
+}
+
+func TestWidget(t *testing.T) {
+	app := App
+	defer func() {
+		App = app
+	}()
+	App = NewApplication()
+	App.Cache = NewMemoryCache(0)
+	widget := &alertWidget{}
+	RegisterWidget("alert", widget)
+
+	engine := NewHtmlEngine()
+	engine.SetFS(fstest.MapFS{
+		"widgets/alert.html": {Data: []byte(`<div class="alert">{{.message}}</div>`)},
+	})
+
+	for i := 0; i < 2; i++ {
+		controller := &WebController{ViewPath: "index", ViewEngine: engine, View: NewView("", "", "")}
+		html, err := engine.Render(
+			`{{head}}|{{widget "alert" "message" "<saved>"}}`,
+			controller.getViewScope(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `<link rel="stylesheet" type="text/css" href="/css/alert.css"/>|<div class="alert">&lt;saved&gt;</div>`
+		if html = controller.View.replaceBlocks(html); html != expected {
+			t.Errorf("expected %s, got %s", expected, html)
+		}
+	}
+	if widget.prepares != 1 {
+		t.Errorf("expected the widget's output to be cached, prepared %d times", widget.prepares)
+	}
+
+	// The output is cached by the host and theme.
+	for _, theme := range []*Theme{NewTheme("dark", nil), NewTheme("light", nil)} {
+		controller := &WebController{ViewPath: "index", ViewEngine: engine, View: NewView("", "", ""), Theme: theme}
+		if _, err := controller.RenderWidget("alert", map[string]interface{}{"message": "<saved>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if widget.prepares != 3 {
+		t.Errorf("The widget's output should be cached by the theme.\nthe wrong result: prepared %d times", widget.prepares)
+	}
+
+	// The widget is the lambda of mustache.
+	mustache := NewMustacheEngine()
+	mustache.SetFS(fstest.MapFS{
+		"widgets/alert.html": {Data: []byte(`<div class="alert">{{message}}</div>`)},
+	})
+	controller := &WebController{ViewPath: "index", ViewEngine: mustache, View: NewView("", "", "")}
+	html, err := mustache.Render(`{{#widget "alert" "message" message}}{{/widget}}`, map[string]interface{}{"message": "<mustache>"}, controller.getViewScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `<div class="alert">&lt;mustache&gt;</div>`; html != expected {
+		t.Errorf("The rendered widget should be %s.\nthe wrong result: %s", expected, html)
+	}
+
+	if _, err := (&WebController{ViewEngine: engine}).RenderWidget("missing", nil); err == nil {
+		t.Error("expected the error of the unregistered widget")
+	}
+	if _, err = mustache.Render(`{{#widget "alert" "message"}}{{/widget}}`, controller.getViewScope()); err == nil {
+		t.Errorf("The error of the odd number of the widget's params should be returned.")
+	}
+}
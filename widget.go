@@ -0,0 +1,95 @@
+// Copyright 2016 HeadwindFly. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package cheetah
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"path"
+	"time"
+)
+
+// The prefix of the fragment keys of the cached widgets.
+const widgetCachePrefix = "widget:"
+
+// Widget is a reusable view component, such as the pagination bar, form field and alert box.
+// It prepares the context of its template by the params, and registers its assets on the page's view.
+// The widget is shared by the requests, it should not keep the state of a request.
+type Widget interface {
+	// Register the CSS and JavaScript assets on the page's view, it is invoked even if the output is cached.
+	RegisterAssets(view *View)
+
+	// Prepare the context of the template by the params.
+	Prepare(controller *WebController, params map[string]interface{}) (interface{}, error)
+
+	// Get the template name without suffix, it is relative to the widgets' directory.
+	Template() string
+}
+
+// CacheableWidget is the widget whose output is cached as a fragment.
+type CacheableWidget interface {
+	Widget
+
+	// Get the cache key, TTL and dependencies of the output by the params.
+	// The output will not be cached if the key is empty.
+	CacheKey(params map[string]interface{}) (key string, ttl time.Duration, dependencies []CacheDependency)
+}
+
+// Register the widget, it should be invoked before running the application.
+func RegisterWidget(name string, widget Widget) {
+	App.widgets[name] = widget
+}
+
+// Render the widget with the params and returns the HTML, the widget's assets are registered on the controller's view.
+// The views call the widget by the view function widget with the params which are given as key-value pairs,
+// such as {{widget "pagination" "page" .page}} with HtmlEngine, and {{#widget "pagination" "page" page}}{{/widget}}
+// with mustache. The cached output is keyed by the request's host and the theme, see also CacheableWidget.
+func (this *WebController) RenderWidget(name string, params map[string]interface{}) (string, error) {
+	widget, ok := App.widgets[name]
+	if !ok {
+		return "", errors.New("The widget is not registered: " + name)
+	}
+
+	if this.View != nil {
+		widget.RegisterAssets(this.View)
+	}
+
+	render := func() (string, error) {
+		context, err := widget.Prepare(this, params)
+		if err != nil {
+			return "", err
+		}
+		return this.ViewEngine.RenderFile(this.getWidgetFile(widget), context, this.getViewScope())
+	}
+
+	if cacheable, ok := widget.(CacheableWidget); ok {
+		if key, ttl, dependencies := cacheable.CacheKey(params); len(key) > 0 {
+			return this.getFragment(widgetCachePrefix+name+":"+key, ttl, render, dependencies)
+		}
+	}
+	return render()
+}
+
+func (this *WebController) getWidgetFile(widget Widget) string {
+	return path.Join(path.Dir(this.ViewPath), App.Config.viewWidgetDir, widget.Template()+App.Config.viewSuffix)
+}
+
+// The widgets helper, the widgets are rendered by the Render method with the params which are given as key-value pairs.
+type widgetViewHelper struct {
+	controller *WebController
+}
+
+// An error will be returned if the number of the pairs' values is odd.
+func (this *widgetViewHelper) Render(name string, pairs ...interface{}) (template.HTML, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("The params of the widget " + name + " should be key-value pairs.")
+	}
+	params := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		params[fmt.Sprint(pairs[i])] = pairs[i+1]
+	}
+	html, err := this.controller.RenderWidget(name, params)
+	return template.HTML(html), err
+}